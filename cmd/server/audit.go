@@ -0,0 +1,30 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"github.com/AYM1607/godig/pkg/audit"
+)
+
+// newAuditSink returns the sink of the audit log, nil when it's disabled.
+func newAuditSink(cfg ServerConfig) (audit.Sink, error) {
+	switch cfg.AuditLog {
+	case "":
+		return nil, nil
+	case "-":
+		return audit.NewJSONSink(os.Stdout), nil
+	default:
+		return audit.OpenFile(cfg.AuditLog, cfg.AuditLogMaxBytes, cfg.AuditLogMaxBackups)
+	}
+}
+
+// closeAuditSink closes the audit log file, if the sink writes to one.
+func closeAuditSink(sink audit.Sink) {
+	if closer, ok := sink.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("Failed to close audit log: %v", err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/AYM1607/godig/pkg/auth"
+)
+
+// newAuthenticator returns the authenticator of the configured backend. Only
+// that backend checks tunnels, e.g. API keys stop working with jwt.
+func newAuthenticator(cfg ServerConfig) (auth.Authenticator, error) {
+	if cfg.AuthBackend != authKey && cfg.APIKey != "" {
+		log.Printf("auth.api_key (GODIG_API_KEY) is ignored, tunnels authenticate with the %s backend", cfg.AuthBackend)
+	}
+	switch cfg.AuthBackend {
+	case authKeyFile:
+		return auth.LoadKeyFile(cfg.AuthKeyFile)
+	case authJWT:
+		return auth.JWT([]byte(cfg.JWTSecret)), nil
+	case authWebhook:
+		return auth.Webhook(cfg.AuthWebhookURL, &http.Client{Timeout: 10 * time.Second}), nil
+	default:
+		return auth.StaticKey(cfg.APIKey), nil
+	}
+}
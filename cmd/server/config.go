@@ -0,0 +1,472 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/AYM1607/godig/pkg/accesslog"
+	"github.com/AYM1607/godig/pkg/server"
+	"github.com/AYM1607/godig/pkg/session"
+)
+
+// ServerConfig holds the settings of godig-server, the tunnel limits and the
+// deployment options.
+type ServerConfig struct {
+	server.Config
+
+	// Host is the domain tunnels are served under, HostAliases more domains
+	// serving them.
+	Host        string
+	HostAliases []string
+	// PublicPort is the HTTPS port public clients reach the tunnels on, when
+	// the public listener sits behind a proxy. Zero means 443.
+	PublicPort int
+
+	// TunnelAddr accepts tunnel connections over TCP, HTTPAddr the public
+	// requests.
+	TunnelAddr string
+	HTTPAddr   string
+
+	// TLSCertFile and TLSKeyFile enable TLS, and with it HTTP/2, on the public
+	// listener. Without them HTTP/2 is still served in cleartext (h2c) to
+	// clients or proxies with prior knowledge.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// QUICAddr is the UDP address tunnel connections are accepted on over
+	// QUIC, which requires TLS. It defaults to the UDP counterpart of
+	// TunnelAddr when TLS is enabled, empty disables it.
+	QUICAddr    string
+	quicAddrSet bool
+
+	// LogFormat is the format of the access logs written to stdout, json or
+	// text.
+	LogFormat string
+	// AdminAddr is the address of the admin listener serving the health and
+	// readiness probes. Empty disables it.
+	AdminAddr string
+	// OTLPEndpoint is the URL traces are exported to over OTLP/HTTP. When
+	// empty the standard OTEL_EXPORTER_OTLP_* variables are used, if set.
+	OTLPEndpoint string
+
+	// AuthBackend checks the credentials of tunnels: key, the single APIKey,
+	// key-file, the keys in AuthKeyFile, jwt, tokens signed with JWTSecret,
+	// or webhook, approved by the service at AuthWebhookURL. Only one backend
+	// is active, APIKey is ignored by the others.
+	AuthBackend    string
+	APIKey         string
+	AuthKeyFile    string
+	JWTSecret      string
+	AuthWebhookURL string
+
+	// AuditLog is the file the audit log is appended to, - for stdout. Empty
+	// disables it. The file is rotated once it reaches AuditLogMaxBytes,
+	// keeping AuditLogMaxBackups rotated files.
+	AuditLog           string
+	AuditLogMaxBytes   int64
+	AuditLogMaxBackups int
+}
+
+// Authentication backends.
+const (
+	authKey     = "key"
+	authKeyFile = "key-file"
+	authJWT     = "jwt"
+	authWebhook = "webhook"
+)
+
+// minJWTSecretBytes is the shortest secret accepted to sign tokens.
+const minJWTSecretBytes = 32
+
+func defaultServerConfig() ServerConfig {
+	return ServerConfig{
+		Config:     server.DefaultConfig(),
+		Host:       "localhost",
+		TunnelAddr: ":8080",
+		HTTPAddr:   ":8081",
+		LogFormat:  accesslog.FormatJSON,
+		AdminAddr:  ":8082",
+
+		AuthBackend: authKey,
+
+		AuditLogMaxBytes:   100 << 20,
+		AuditLogMaxBackups: 5,
+	}
+}
+
+// loadServerConfig builds the server configuration from the defaults, the
+// configuration file, the GODIG_* environment variables and the flags in
+// args, each overriding the previous ones. The file is set with --config or
+// GODIG_CONFIG.
+func loadServerConfig(args []string) (ServerConfig, error) {
+	cfg := defaultServerConfig()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet("godig-server", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("GODIG_CONFIG"), "Path to the YAML configuration file")
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		values[s.flag] = fs.String(s.flag, "", fmt.Sprintf("%s (%s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return cfg, err
+		}
+	}
+	if err := cfg.loadEnv(settings); err != nil {
+		return cfg, err
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && err == nil {
+				if setErr := s.value.set(*values[f.Name]); setErr != nil {
+					err = fmt.Errorf("invalid -%s: %w", f.Name, setErr)
+				}
+			}
+		}
+	})
+	if err != nil {
+		return cfg, err
+	}
+	if !cfg.quicAddrSet && cfg.TLSCertFile != "" {
+		cfg.QUICAddr = cfg.TunnelAddr
+	}
+
+	return cfg, cfg.validate()
+}
+
+// setting is a configuration file key that can be overridden by an
+// environment variable and a flag.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	value settingValue
+}
+
+// settingValue parses an override into the setting it points to.
+type settingValue struct {
+	set func(string) error
+	// text values apply empty environment variables too, e.g. to disable a
+	// listener. Other values ignore them.
+	text bool
+}
+
+// settings returns the settings of cfg that can be overridden, every key of
+// the configuration file.
+func (cfg *ServerConfig) settings() []setting {
+	return []setting{
+		{"host", "GODIG_HOST", "Domain tunnels are served under", textValue(&cfg.Host)},
+		{"host-aliases", "GODIG_HOST_ALIASES", "Comma separated domains tunnels are also served under", listValue(&cfg.HostAliases)},
+		{"public-port", "GODIG_PUBLIC_PORT", "HTTPS port public clients reach the tunnels on, 0 for 443", intValue(&cfg.PublicPort)},
+		{"tunnel-addr", "GODIG_TUNNEL_ADDR", "Address accepting tunnel connections over TCP", textValue(&cfg.TunnelAddr)},
+		{"quic-addr", "GODIG_QUIC_ADDR", "UDP address accepting tunnel connections over QUIC, empty disables it", settingValue{
+			set:  func(v string) error { cfg.QUICAddr, cfg.quicAddrSet = v, true; return nil },
+			text: true,
+		}},
+		{"http-addr", "GODIG_HTTP_ADDR", "Address accepting public requests", textValue(&cfg.HTTPAddr)},
+		{"tls-cert", "GODIG_TLS_CERT_FILE", "TLS certificate file", textValue(&cfg.TLSCertFile)},
+		{"tls-key", "GODIG_TLS_KEY_FILE", "TLS key file", textValue(&cfg.TLSKeyFile)},
+		{"auth", "GODIG_AUTH", "Authentication backend: key, key-file, jwt or webhook", textValue(&cfg.AuthBackend)},
+		{"api-key", "GODIG_API_KEY", "API key of the key backend", textValue(&cfg.APIKey)},
+		{"auth-key-file", "GODIG_AUTH_KEY_FILE", "Key file of the key-file backend", textValue(&cfg.AuthKeyFile)},
+		{"jwt-secret", "GODIG_JWT_SECRET", "Secret the tokens of the jwt backend are signed with", textValue(&cfg.JWTSecret)},
+		{"auth-webhook-url", "GODIG_AUTH_WEBHOOK_URL", "URL of the webhook backend", textValue(&cfg.AuthWebhookURL)},
+		{"read-header-timeout", "GODIG_READ_HEADER_TIMEOUT", "Time to read the headers of a public request", durationValue(&cfg.ReadHeaderTimeout)},
+		{"read-timeout", "GODIG_READ_TIMEOUT", "Time to read an entire public request, 0 for no limit", durationValue(&cfg.ReadTimeout)},
+		{"idle-timeout", "GODIG_IDLE_TIMEOUT", "Time keep-alive connections are kept open between requests", durationValue(&cfg.IdleTimeout)},
+		{"stream-idle-timeout", "GODIG_STREAM_IDLE_TIMEOUT", "Default idle timeout of tunnel streams", durationValue(&cfg.StreamIdleTimeout)},
+		{"max-stream-idle-timeout", "GODIG_MAX_STREAM_IDLE_TIMEOUT", "Largest idle timeout a tunnel can ask for", durationValue(&cfg.MaxStreamIdleTimeout)},
+		{"max-request-duration", "GODIG_MAX_REQUEST_DURATION", "Largest duration of a request, 0 for no limit", durationValue(&cfg.MaxRequestDuration)},
+		{"keepalive-interval", "GODIG_KEEPALIVE_INTERVAL", "Default keep-alive interval of tunnel sessions", durationValue(&cfg.Session.KeepAliveInterval)},
+		{"connection-write-timeout", "GODIG_CONNECTION_WRITE_TIMEOUT", "Default write timeout of tunnel sessions", durationValue(&cfg.Session.ConnectionWriteTimeout)},
+		{"heartbeat-interval", "GODIG_HEARTBEAT_INTERVAL", "Default heartbeat interval of tunnel sessions", durationValue(&cfg.Session.HeartbeatInterval)},
+		{"max-header-bytes", "GODIG_MAX_HEADER_BYTES", "Largest size of the request line and headers", intValue(&cfg.MaxHeaderBytes)},
+		{"max-request-body-bytes", "GODIG_MAX_REQUEST_BODY_BYTES", "Largest request body forwarded to a tunnel, 0 for no limit", intValue(&cfg.MaxRequestBodyBytes)},
+		{"stream-window-size", "GODIG_STREAM_WINDOW_SIZE", "Default stream window of tunnel sessions, in bytes", intValue(&cfg.Session.MaxStreamWindowSize)},
+		{"max-stream-window-size", "GODIG_MAX_STREAM_WINDOW_SIZE", "Largest stream window a tunnel can ask for, in bytes", intValue(&cfg.MaxStreamWindowSize)},
+		{"log-format", "GODIG_LOG_FORMAT", "Access log format: json or text", textValue(&cfg.LogFormat)},
+		{"otlp-endpoint", "GODIG_OTLP_ENDPOINT", "URL traces are exported to over OTLP/HTTP", textValue(&cfg.OTLPEndpoint)},
+		{"audit-log", "GODIG_AUDIT_LOG", "Audit log file, - for stdout", textValue(&cfg.AuditLog)},
+		{"audit-log-max-bytes", "GODIG_AUDIT_LOG_MAX_BYTES", "Size the audit log is rotated at, 0 to never rotate", intValue(&cfg.AuditLogMaxBytes)},
+		{"audit-log-max-backups", "GODIG_AUDIT_LOG_MAX_BACKUPS", "Rotated audit log files kept", intValue(&cfg.AuditLogMaxBackups)},
+		{"admin-addr", "GODIG_ADMIN_ADDR", "Address of the health and readiness probes, empty disables it", textValue(&cfg.AdminAddr)},
+	}
+}
+
+func textValue(p *string) settingValue {
+	return settingValue{
+		set:  func(v string) error { *p = v; return nil },
+		text: true,
+	}
+}
+
+// listValue parses a comma separated list.
+func listValue(p *[]string) settingValue {
+	return settingValue{
+		set:  func(v string) error { *p = splitList(v); return nil },
+		text: true,
+	}
+}
+
+func durationValue(p *time.Duration) settingValue {
+	return settingValue{set: func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		if d < 0 {
+			return errors.New("must not be negative")
+		}
+		*p = d
+		return nil
+	}}
+}
+
+func intValue[T int | int64 | uint32](p *T) settingValue {
+	return settingValue{set: func(v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return errors.New("must not be negative")
+		}
+		if int64(T(n)) != n {
+			return errors.New("value out of range")
+		}
+		*p = T(n)
+		return nil
+	}}
+}
+
+// configFile is the layout of the configuration file. Its fields point into
+// a ServerConfig, so the settings missing from the file keep their value.
+type configFile struct {
+	Host       *string   `yaml:"host"`
+	Aliases    *[]string `yaml:"aliases"`
+	PublicPort *int      `yaml:"public_port"`
+	Listen     struct {
+		Tunnel *string `yaml:"tunnel"`
+		// QUIC is left nil, to tell whether the file sets it.
+		QUIC *string `yaml:"quic"`
+		HTTP *string `yaml:"http"`
+	} `yaml:"listen"`
+	TLS struct {
+		CertFile *string `yaml:"cert_file"`
+		KeyFile  *string `yaml:"key_file"`
+	} `yaml:"tls"`
+	Auth struct {
+		Backend    *string `yaml:"backend"`
+		APIKey     *string `yaml:"api_key"`
+		KeyFile    *string `yaml:"key_file"`
+		JWTSecret  *string `yaml:"jwt_secret"`
+		WebhookURL *string `yaml:"webhook_url"`
+	} `yaml:"auth"`
+	Timeouts struct {
+		ReadHeader         *time.Duration `yaml:"read_header"`
+		Read               *time.Duration `yaml:"read"`
+		Idle               *time.Duration `yaml:"idle"`
+		StreamIdle         *time.Duration `yaml:"stream_idle"`
+		MaxStreamIdle      *time.Duration `yaml:"max_stream_idle"`
+		MaxRequestDuration *time.Duration `yaml:"max_request_duration"`
+		KeepAliveInterval  *time.Duration `yaml:"keepalive_interval"`
+		ConnectionWrite    *time.Duration `yaml:"connection_write"`
+		HeartbeatInterval  *time.Duration `yaml:"heartbeat_interval"`
+	} `yaml:"timeouts"`
+	Limits struct {
+		MaxHeaderBytes      *int    `yaml:"max_header_bytes"`
+		MaxRequestBodyBytes *int64  `yaml:"max_request_body_bytes"`
+		StreamWindowSize    *uint32 `yaml:"stream_window_size"`
+		MaxStreamWindowSize *uint32 `yaml:"max_stream_window_size"`
+	} `yaml:"limits"`
+	Log struct {
+		Format       *string `yaml:"format"`
+		OTLPEndpoint *string `yaml:"otlp_endpoint"`
+		Audit        struct {
+			File       *string `yaml:"file"`
+			MaxBytes   *int64  `yaml:"max_bytes"`
+			MaxBackups *int    `yaml:"max_backups"`
+		} `yaml:"audit"`
+	} `yaml:"log"`
+	Admin struct {
+		Addr *string `yaml:"addr"`
+	} `yaml:"admin"`
+}
+
+// loadFile applies the settings of the YAML file at path:
+//
+//	host: godig.xyz
+//	public_port: 443
+//	listen:
+//	  tunnel: ":8080"
+//	  http: ":8081"
+//	tls:
+//	  cert_file: /etc/godig/cert.pem
+//	  key_file: /etc/godig/key.pem
+//	auth:
+//	  backend: key-file
+//	  key_file: /etc/godig/keys.yaml
+//	timeouts:
+//	  stream_idle: 2m
+//	log:
+//	  audit:
+//	    file: /var/log/godig/audit.log
+func (cfg *ServerConfig) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var file configFile
+	file.Host = &cfg.Host
+	file.Aliases = &cfg.HostAliases
+	file.PublicPort = &cfg.PublicPort
+	file.Listen.Tunnel = &cfg.TunnelAddr
+	file.Listen.HTTP = &cfg.HTTPAddr
+	file.TLS.CertFile = &cfg.TLSCertFile
+	file.TLS.KeyFile = &cfg.TLSKeyFile
+	file.Auth.Backend = &cfg.AuthBackend
+	file.Auth.APIKey = &cfg.APIKey
+	file.Auth.KeyFile = &cfg.AuthKeyFile
+	file.Auth.JWTSecret = &cfg.JWTSecret
+	file.Auth.WebhookURL = &cfg.AuthWebhookURL
+	file.Timeouts.ReadHeader = &cfg.ReadHeaderTimeout
+	file.Timeouts.Read = &cfg.ReadTimeout
+	file.Timeouts.Idle = &cfg.IdleTimeout
+	file.Timeouts.StreamIdle = &cfg.StreamIdleTimeout
+	file.Timeouts.MaxStreamIdle = &cfg.MaxStreamIdleTimeout
+	file.Timeouts.MaxRequestDuration = &cfg.MaxRequestDuration
+	file.Timeouts.KeepAliveInterval = &cfg.Session.KeepAliveInterval
+	file.Timeouts.ConnectionWrite = &cfg.Session.ConnectionWriteTimeout
+	file.Timeouts.HeartbeatInterval = &cfg.Session.HeartbeatInterval
+	file.Limits.MaxHeaderBytes = &cfg.MaxHeaderBytes
+	file.Limits.MaxRequestBodyBytes = &cfg.MaxRequestBodyBytes
+	file.Limits.StreamWindowSize = &cfg.Session.MaxStreamWindowSize
+	file.Limits.MaxStreamWindowSize = &cfg.MaxStreamWindowSize
+	file.Log.Format = &cfg.LogFormat
+	file.Log.OTLPEndpoint = &cfg.OTLPEndpoint
+	file.Log.Audit.File = &cfg.AuditLog
+	file.Log.Audit.MaxBytes = &cfg.AuditLogMaxBytes
+	file.Log.Audit.MaxBackups = &cfg.AuditLogMaxBackups
+	file.Admin.Addr = &cfg.AdminAddr
+
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	if file.Listen.QUIC != nil {
+		cfg.QUICAddr, cfg.quicAddrSet = *file.Listen.QUIC, true
+	}
+	return nil
+}
+
+// loadEnv applies the environment variables of settings that are set.
+func (cfg *ServerConfig) loadEnv(settings []setting) error {
+	for _, s := range settings {
+		v, ok := os.LookupEnv(s.env)
+		if !ok || (v == "" && !s.value.text) {
+			continue
+		}
+		if err := s.value.set(v); err != nil {
+			return fmt.Errorf("invalid %s: %w", s.env, err)
+		}
+	}
+	return nil
+}
+
+// validate reports the first invalid setting, named by its configuration file
+// key and environment variable.
+func (cfg ServerConfig) validate() error {
+	if cfg.Host == "" {
+		return fmt.Errorf("host (GODIG_HOST) must not be empty")
+	}
+	if cfg.PublicPort > 65535 {
+		return fmt.Errorf("public_port (GODIG_PUBLIC_PORT) must be a port number")
+	}
+	if cfg.TunnelAddr == "" || cfg.HTTPAddr == "" {
+		return fmt.Errorf("listen.tunnel (GODIG_TUNNEL_ADDR) and listen.http (GODIG_HTTP_ADDR) must not be empty")
+	}
+	if cfg.ReadHeaderTimeout <= 0 {
+		return fmt.Errorf("timeouts.read_header (GODIG_READ_HEADER_TIMEOUT) must be greater than 0")
+	}
+	if cfg.ReadTimeout < 0 || cfg.IdleTimeout < 0 || cfg.MaxRequestDuration < 0 {
+		return fmt.Errorf("timeouts.read, timeouts.idle and timeouts.max_request_duration must not be negative")
+	}
+	if cfg.MaxHeaderBytes <= 0 {
+		return fmt.Errorf("limits.max_header_bytes (GODIG_MAX_HEADER_BYTES) must be greater than 0")
+	}
+	if cfg.MaxRequestBodyBytes < 0 {
+		return fmt.Errorf("limits.max_request_body_bytes (GODIG_MAX_REQUEST_BODY_BYTES) must not be negative")
+	}
+	if cfg.StreamIdleTimeout <= 0 {
+		return fmt.Errorf("timeouts.stream_idle (GODIG_STREAM_IDLE_TIMEOUT) must be greater than 0")
+	}
+	if cfg.MaxStreamIdleTimeout < cfg.StreamIdleTimeout {
+		return fmt.Errorf("timeouts.max_stream_idle (GODIG_MAX_STREAM_IDLE_TIMEOUT) must not be lower than timeouts.stream_idle")
+	}
+	if cfg.Session.MaxStreamWindowSize < session.MinStreamWindowSize {
+		return fmt.Errorf("limits.stream_window_size (GODIG_STREAM_WINDOW_SIZE) must be at least %d", session.MinStreamWindowSize)
+	}
+	if cfg.MaxStreamWindowSize < cfg.Session.MaxStreamWindowSize {
+		return fmt.Errorf("limits.max_stream_window_size (GODIG_MAX_STREAM_WINDOW_SIZE) must not be lower than limits.stream_window_size")
+	}
+	if cfg.Session.KeepAliveInterval <= 0 || cfg.Session.ConnectionWriteTimeout <= 0 || cfg.Session.HeartbeatInterval <= 0 {
+		return fmt.Errorf("timeouts.keepalive_interval, timeouts.connection_write and timeouts.heartbeat_interval must be greater than 0")
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return fmt.Errorf("tls.cert_file (GODIG_TLS_CERT_FILE) and tls.key_file (GODIG_TLS_KEY_FILE) must be set together")
+	}
+	if cfg.QUICAddr != "" && cfg.TLSCertFile == "" {
+		return fmt.Errorf("listen.quic (GODIG_QUIC_ADDR) requires tls.cert_file and tls.key_file")
+	}
+	if cfg.LogFormat != accesslog.FormatJSON && cfg.LogFormat != accesslog.FormatText {
+		return fmt.Errorf("log.format (GODIG_LOG_FORMAT) must be %s or %s", accesslog.FormatJSON, accesslog.FormatText)
+	}
+	if cfg.AuditLogMaxBytes < 0 || cfg.AuditLogMaxBackups < 0 {
+		return fmt.Errorf("log.audit.max_bytes and log.audit.max_backups must not be negative")
+	}
+	switch cfg.AuthBackend {
+	case authKey:
+		if cfg.APIKey == "" {
+			return fmt.Errorf("auth.backend=%s requires auth.api_key (GODIG_API_KEY)", authKey)
+		}
+	case authKeyFile:
+		if cfg.AuthKeyFile == "" {
+			return fmt.Errorf("auth.backend=%s requires auth.key_file (GODIG_AUTH_KEY_FILE)", authKeyFile)
+		}
+	case authJWT:
+		if len(cfg.JWTSecret) < minJWTSecretBytes {
+			return fmt.Errorf("auth.backend=%s requires an auth.jwt_secret (GODIG_JWT_SECRET) of at least %d bytes", authJWT, minJWTSecretBytes)
+		}
+	case authWebhook:
+		if cfg.AuthWebhookURL == "" {
+			return fmt.Errorf("auth.backend=%s requires auth.webhook_url (GODIG_AUTH_WEBHOOK_URL)", authWebhook)
+		}
+	default:
+		return fmt.Errorf("auth.backend (GODIG_AUTH) must be %s, %s, %s or %s", authKey, authKeyFile, authJWT, authWebhook)
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
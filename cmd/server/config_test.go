@@ -0,0 +1,159 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "godig-server.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadServerConfig(t *testing.T) {
+	path := writeConfig(t, `
+host: godig.test
+aliases: [tunnels.test]
+public_port: 8443
+listen:
+  tunnel: ":9080"
+  http: ":9081"
+auth:
+  api_key: from-file
+timeouts:
+  stream_idle: 2m
+limits:
+  max_request_body_bytes: 1024
+admin:
+  addr: ""
+`)
+	t.Setenv("GODIG_CONFIG", path)
+	t.Setenv("GODIG_HTTP_ADDR", ":9091")
+	t.Setenv("GODIG_API_KEY", "from-env")
+
+	cfg, err := loadServerConfig([]string{"--tunnel-addr", ":9090"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Host != "godig.test" || len(cfg.HostAliases) != 1 || cfg.HostAliases[0] != "tunnels.test" {
+		t.Errorf("expected the hosts of the file, got %q and %v", cfg.Host, cfg.HostAliases)
+	}
+	if cfg.PublicPort != 8443 {
+		t.Errorf("expected the public port of the file, got %d", cfg.PublicPort)
+	}
+	if cfg.TunnelAddr != ":9090" || cfg.HTTPAddr != ":9091" {
+		t.Errorf("expected flags then env to override the file, got %q and %q", cfg.TunnelAddr, cfg.HTTPAddr)
+	}
+	if cfg.APIKey != "from-env" {
+		t.Errorf("expected the env API key, got %q", cfg.APIKey)
+	}
+	if cfg.StreamIdleTimeout != 2*time.Minute || cfg.MaxRequestBodyBytes != 1024 || cfg.AdminAddr != "" {
+		t.Errorf("unexpected settings from the file %+v", cfg)
+	}
+	if cfg.ReadHeaderTimeout != defaultServerConfig().ReadHeaderTimeout {
+		t.Errorf("expected the settings missing from the file to keep their default, got %v", cfg.ReadHeaderTimeout)
+	}
+}
+
+func TestLoadServerConfig_Flags(t *testing.T) {
+	t.Setenv("GODIG_API_KEY", "secret")
+	t.Setenv("GODIG_MAX_REQUEST_BODY_BYTES", "2048")
+	path := writeConfig(t, "timeouts:\n  stream_idle: 2m\n")
+
+	cfg, err := loadServerConfig([]string{
+		"--config", path,
+		"--max-request-body-bytes", "4096",
+		"--stream-idle-timeout", "90s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MaxRequestBodyBytes != 4096 {
+		t.Errorf("expected the flag to override the env body limit, got %d", cfg.MaxRequestBodyBytes)
+	}
+	if cfg.StreamIdleTimeout != 90*time.Second {
+		t.Errorf("expected the flag to override the file stream idle timeout, got %v", cfg.StreamIdleTimeout)
+	}
+
+	for _, args := range [][]string{
+		{"--max-request-body-bytes", "-1"},
+		{"--stream-idle-timeout", "soon"},
+		{"--stream-window-size", "4294967296"},
+	} {
+		if _, err := loadServerConfig(args); err == nil || !strings.Contains(err.Error(), args[0][1:]) {
+			t.Errorf("expected %v to be refused, got %v", args, err)
+		}
+	}
+}
+
+func TestLoadServerConfig_QUICDefault(t *testing.T) {
+	t.Setenv("GODIG_API_KEY", "secret")
+	t.Setenv("GODIG_TLS_CERT_FILE", "cert.pem")
+	t.Setenv("GODIG_TLS_KEY_FILE", "key.pem")
+
+	cfg, err := loadServerConfig([]string{"--tunnel-addr", ":9080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.QUICAddr != ":9080" {
+		t.Errorf("expected QUIC on the tunnel address with TLS, got %q", cfg.QUICAddr)
+	}
+
+	cfg, err = loadServerConfig([]string{"--config", writeConfig(t, "listen:\n  quic: \"\"\n")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.QUICAddr != "" {
+		t.Errorf("expected the file to disable QUIC, got %q", cfg.QUICAddr)
+	}
+}
+
+func TestLoadServerConfig_Invalid(t *testing.T) {
+	t.Setenv("GODIG_API_KEY", "secret")
+
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{name: "unknown key", config: "listen:\n  tunel: \":8080\"\n", wantErr: "field tunel not found"},
+		{name: "invalid duration", config: "timeouts:\n  stream_idle: soon\n", wantErr: "invalid config file"},
+		{name: "stream idle above max", config: "timeouts:\n  stream_idle: 1h\n", wantErr: "timeouts.max_stream_idle"},
+		{name: "unknown auth backend", config: "auth:\n  backend: ldap\n", wantErr: "auth.backend"},
+		{name: "short JWT secret", config: "auth:\n  backend: jwt\n  jwt_secret: short\n", wantErr: "auth.jwt_secret"},
+		{name: "invalid public port", config: "public_port: 70000\n", wantErr: "public_port"},
+		{name: "QUIC without TLS", config: "listen:\n  quic: \":8080\"\n", wantErr: "listen.quic"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadServerConfig([]string{"--config", writeConfig(t, tt.config)})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRestartSettings(t *testing.T) {
+	startup := defaultServerConfig()
+	cfg := startup
+	cfg.MaxRequestBodyBytes = 1
+	cfg.AuthBackend = authJWT
+	if changed := restartSettings(startup, cfg); len(changed) != 0 {
+		t.Errorf("expected limits and auth to be reloadable, got %v", changed)
+	}
+
+	cfg.HTTPAddr = ":9081"
+	cfg.ReadHeaderTimeout = time.Second
+	if changed := restartSettings(startup, cfg); len(changed) != 2 {
+		t.Errorf("expected listen and the public listener timeouts to need a restart, got %v", changed)
+	}
+}
@@ -1,284 +1,142 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
-	"io"
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"log"
 	"net"
 	"net/http"
 	"os"
-	"strings"
-	"sync"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/hashicorp/yamux"
-
-	"github.com/AYM1607/godig/pkg/auth"
-	"github.com/AYM1607/godig/pkg/headers"
-	"github.com/AYM1607/godig/types"
+	"github.com/AYM1607/godig/pkg/accesslog"
+	"github.com/AYM1607/godig/pkg/server"
+	"github.com/AYM1607/godig/pkg/telemetry"
+	"github.com/AYM1607/godig/pkg/transport"
 )
 
-type TunnelServer struct {
-	clients map[string]*ClientSession
-	mutex   sync.RWMutex
-	apiKey  string
-}
-
-type ClientSession struct {
-	ID      string
-	Session *yamux.Session
-	Conn    net.Conn
-	Bearer  *string
-}
-
 func main() {
-	server := NewTunnelServer()
-
-	go func() {
-		listener, err := net.Listen("tcp", ":8080")
-		if err != nil {
-			log.Fatal("Failed to start tunnel listener:", err)
-		}
-		defer listener.Close()
-
-		log.Println("Tunnel server listening on :8080")
-
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				log.Printf("Failed to accept connection: %v", err)
-				continue
-			}
-
-			go server.handleTunnelConnection(conn)
-		}
-	}()
-
-	http.HandleFunc("/", server.ServeHTTP)
-
-	log.Println("HTTP server listening on :8081")
-	log.Printf("Access tunnels at: https://{tunnel-id}.%s:8081\n", getHost())
-	log.Fatal(http.ListenAndServe(":8081", nil))
-}
-
-func NewTunnelServer() *TunnelServer {
-	key, err := auth.GetServerKey()
-	if err != nil {
-		log.Fatalln(err)
-	}
-	return &TunnelServer{
-		clients: make(map[string]*ClientSession),
-		apiKey:  key,
-	}
-}
-
-func (ts *TunnelServer) handleTunnelConnection(conn net.Conn) {
-	defer conn.Close()
-
-	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
-
-	decoder := json.NewDecoder(conn)
-	var handshake types.HandshakeMessage
-	if err := decoder.Decode(&handshake); err != nil {
-		log.Printf("Failed to read handshake: %v", err)
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		handleTokenCommand()
 		return
 	}
 
-	if handshake.APIKey != ts.apiKey {
-		log.Printf("Invalid API Key")
+	cfg, err := loadServerConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
 		return
 	}
-
-	// TODO: Validate max length.
-	if handshake.TunnelID == "" {
-		log.Printf("Invalid tunnel ID in handshake")
-		return
-	}
-
-	authMode := "authenticated"
-	if handshake.Bearer == nil {
-		authMode = "public (no auth)"
-	}
-
-	log.Printf("Client connecting with tunnel ID: %s (%s)", handshake.TunnelID, authMode)
-
-	// Send acknowledgment
-	response := map[string]string{"status": "ok"}
-	encoder := json.NewEncoder(conn)
-	if err := encoder.Encode(response); err != nil {
-		log.Printf("Failed to send handshake response: %v", err)
-		return
-	}
-
-	// Clear read deadline. TODO: Understand why this is needed.
-	conn.SetReadDeadline(time.Time{})
-
-	session, err := yamux.Server(conn, yamux.DefaultConfig())
 	if err != nil {
-		log.Printf("Failed to create yamux session: %v", err)
-		return
+		log.Fatalln("Invalid server configuration:", err)
 	}
 
-	// Register client
-	clientSession := &ClientSession{
-		ID:      handshake.TunnelID,
-		Session: session,
-		Conn:    conn,
-		Bearer:  handshake.Bearer,
+	authenticator, err := newAuthenticator(cfg)
+	if err != nil {
+		log.Fatalln(err)
 	}
 
-	ts.registerClient(clientSession)
-	defer ts.unregisterClient(handshake.TunnelID)
-
-	log.Printf("Tunnel established for %s.tunnel.local", handshake.TunnelID)
-
-	// Keep connection alive until client disconnects
-	<-session.CloseChan()
-	log.Printf("Client %s disconnected", handshake.TunnelID)
-}
-
-func (ts *TunnelServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Extract tunnel ID from subdomain
-	host := r.Host
-	if strings.Contains(host, ":") {
-		host = strings.Split(host, ":")[0]
+	logHandler, err := accesslog.NewHandler(os.Stdout, cfg.LogFormat)
+	if err != nil {
+		log.Fatalln("Invalid server configuration:", err)
 	}
 
-	parts := strings.Split(host, ".")
-	if len(parts) < 2 {
-		http.Error(w, "Invalid subdomain", http.StatusBadRequest)
-		return
+	auditSink, err := newAuditSink(cfg)
+	if err != nil {
+		log.Fatalln(err)
 	}
 
-	tunnelID := parts[0]
-	if tunnelID == "" {
-		http.Error(w, "Missing tunnel ID", http.StatusBadRequest)
-		return
+	shutdownTracing, err := telemetry.Setup(context.Background(), "godig-server", cfg.OTLPEndpoint)
+	if err != nil {
+		log.Fatalln("Failed to set up tracing:", err)
 	}
 
-	client := ts.getClient(tunnelID)
-	if client == nil {
-		http.Error(w, "Tunnel not found or not connected", http.StatusServiceUnavailable)
-		return
+	opts := server.Options{
+		Host:       cfg.Host,
+		Aliases:    cfg.HostAliases,
+		PublicPort: cfg.PublicPort,
+		Auth:       authenticator,
+		Config:     cfg.Config,
+		AccessLog:  logHandler,
+		Audit:      auditSink,
 	}
 
-	// Only validate bearer token if auth is enabled for this tunnel
-	if client.Bearer != nil {
-		token := getBearerToken(r)
-		if token != *client.Bearer {
-			http.Error(w, "Auth failed", http.StatusUnauthorized)
-			return
+	// The certificate is swapped when the configuration is reloaded.
+	var certificate atomic.Pointer[tls.Certificate]
+	if cfg.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			log.Fatal("Failed to load TLS certificate:", err)
+		}
+		certificate.Store(&cert)
+		opts.TLSConfig = &tls.Config{
+			GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return certificate.Load(), nil
+			},
 		}
 	}
 
-	stream, err := client.Session.Open()
+	opts.TunnelListener, err = net.Listen("tcp", cfg.TunnelAddr)
 	if err != nil {
-		log.Printf("Failed to open stream for %s: %v", tunnelID, err)
-		http.Error(w, "Failed to open tunnel stream", http.StatusBadGateway)
-		return
+		log.Fatal("Failed to start tunnel listener:", err)
 	}
-	defer stream.Close()
-
-	// Must be renewed for long running connections.
-	stream.SetDeadline(time.Now().Add(60 * time.Second))
+	log.Printf("Tunnel server listening on %s", cfg.TunnelAddr)
 
-	// Forward the HTTP request to the client
-	if err := r.Write(stream); err != nil {
-		log.Printf("Failed to write request to stream: %v", err)
-		http.Error(w, "Failed to forward request", http.StatusBadGateway)
-		return
+	if cfg.QUICAddr != "" {
+		opts.QUICListener, err = transport.ListenQUIC(cfg.QUICAddr, opts.TLSConfig.Clone(), cfg.QUICSessionConfig())
+		if err != nil {
+			log.Fatal("Failed to start QUIC listener:", err)
+		}
+		log.Printf("Tunnel server listening for QUIC on %s", cfg.QUICAddr)
 	}
 
-	// Read the HTTP response from the client.
-	resp, err := http.ReadResponse(bufio.NewReader(stream), r)
+	opts.HTTPListener, err = net.Listen("tcp", cfg.HTTPAddr)
 	if err != nil {
-		log.Printf("Failed to read response from stream: %v", err)
-		http.Error(w, "Failed to read response", http.StatusBadGateway)
-		return
-	}
-	defer resp.Body.Close()
-
-	// Get client IP for proxy headers.
-	clientIP, _, _ := net.SplitHostPort(r.RemoteAddr)
-	if clientIP == "" {
-		clientIP = r.RemoteAddr
-	}
-
-	// Clean up request headers and add proxy headers
-	headers.RemoveHopByHopHeaders(r.Header)
-	headers.AddProxyHeaders(r, clientIP)
-
-	// Copy response headers
-	for key, values := range resp.Header {
-		for _, value := range values {
-			w.Header().Add(key, value)
-		}
+		log.Fatal("Failed to start HTTP listener:", err)
 	}
+	log.Printf("HTTP server listening on %s", cfg.HTTPAddr)
 
-	// Send headers before streaming the response to handle SSE gracefully.
-	w.WriteHeader(resp.StatusCode)
-
-	if isStreamingResponse(resp) {
-		log.Printf("Handling streaming response for %s", tunnelID)
-		if err := ts.handleStreamingResponse(w, resp, stream); err != nil {
-			log.Printf("Error handling streaming response: %v", err)
-		}
-	} else {
-		_, err = io.Copy(w, resp.Body)
-		if err != nil {
-			log.Printf("Error copying response body: %v", err)
-		}
+	tunnelServer, err := server.NewTunnelServer(opts)
+	if err != nil {
+		log.Fatalln("Invalid server configuration:", err)
 	}
 
-}
-
-func (ts *TunnelServer) registerClient(client *ClientSession) {
-	ts.mutex.Lock()
-	defer ts.mutex.Unlock()
-
-	// Close existing session if any.
-	if existing, exists := ts.clients[client.ID]; exists {
-		log.Printf("Replacing existing session for tunnel ID: %s", client.ID)
-		// TODO: Handle these errors.
-		existing.Session.Close()
-		existing.Conn.Close()
+	if cfg.AdminAddr != "" {
+		go func() {
+			adminServer := &http.Server{
+				Addr:              cfg.AdminAddr,
+				Handler:           tunnelServer.AdminHandler(),
+				ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			}
+			log.Printf("Admin server listening on %s", cfg.AdminAddr)
+			log.Fatal(adminServer.ListenAndServe())
+		}()
 	}
 
-	ts.clients[client.ID] = client
-}
+	go reloadOnSIGHUP(cfg, tunnelServer, &certificate, auditSink)
 
-func (ts *TunnelServer) unregisterClient(tunnelID string) {
-	ts.mutex.Lock()
-	defer ts.mutex.Unlock()
-	delete(ts.clients, tunnelID)
-}
+	log.Printf("Access tunnels at: %s\n", tunnelServer.TunnelURL("{tunnel-id}"))
 
-func (ts *TunnelServer) getClient(tunnelID string) *ClientSession {
-	ts.mutex.RLock()
-	defer ts.mutex.RUnlock()
-	return ts.clients[tunnelID]
-}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		tunnelServer.Close()
+	}()
 
-func getHost() string {
-	host := os.Getenv("GODIG_HOST")
-	if host == "" {
-		host = "localhost"
-	}
-	return host
-}
+	serveErr := tunnelServer.Serve()
+	closeAuditSink(auditSink)
 
-func getBearerToken(r *http.Request) string {
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		return ""
+	// Flush the spans of the last requests.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
 	}
-
-	// Check if it starts with "Bearer "
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		return ""
+	if serveErr != nil {
+		log.Fatal(serveErr)
 	}
-
-	// Extract the token part
-	return strings.TrimPrefix(authHeader, "Bearer ")
 }
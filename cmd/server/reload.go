@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/AYM1607/godig/pkg/audit"
+	"github.com/AYM1607/godig/pkg/server"
+)
+
+// reloadOnSIGHUP reloads the configuration and reopens the audit log file
+// every time the process gets a SIGHUP. startup is the configuration the
+// server was started with.
+func reloadOnSIGHUP(startup ServerConfig, ts *server.TunnelServer, certificate *atomic.Pointer[tls.Certificate], auditSink audit.Sink) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		// The file may have been rotated by logrotate.
+		if file, ok := auditSink.(*audit.File); ok {
+			if err := file.Reopen(); err != nil {
+				log.Printf("Failed to reopen audit log: %v", err)
+			}
+		}
+
+		event := audit.Event{Type: audit.AdminAction, Action: "reload_config", Subject: "SIGHUP"}
+		if err := reloadConfig(startup, ts, certificate); err != nil {
+			log.Printf("Failed to reload configuration, keeping the current one: %v", err)
+			event.Reason = err.Error()
+		} else {
+			log.Println("Configuration reloaded")
+		}
+		ts.Audit(event)
+	}
+}
+
+// reloadConfig applies the authentication backend, the tunnel limits and the
+// TLS certificate of the configuration as loaded now. Nothing is applied if
+// any of it is invalid. The other settings are only read at startup, changes
+// to them are logged and ignored.
+func reloadConfig(startup ServerConfig, ts *server.TunnelServer, certificate *atomic.Pointer[tls.Certificate]) error {
+	cfg, err := loadServerConfig(os.Args[1:])
+	if err != nil {
+		return err
+	}
+	if ignored := restartSettings(startup, cfg); len(ignored) > 0 {
+		log.Printf("Changes to %s need a restart, they are ignored", strings.Join(ignored, ", "))
+	}
+
+	var cert *tls.Certificate
+	if startup.TLSCertFile != "" && cfg.TLSCertFile != "" {
+		loaded, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		cert = &loaded
+	}
+	authenticator, err := newAuthenticator(cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := ts.Reconfigure(cfg.Config, authenticator); err != nil {
+		return err
+	}
+	if cert != nil {
+		certificate.Store(cert)
+	}
+	return nil
+}
+
+// restartSettings returns the settings that differ between startup and cfg
+// and are only read at startup.
+func restartSettings(startup, cfg ServerConfig) []string {
+	var changed []string
+	check := func(name string, differs bool) {
+		if differs {
+			changed = append(changed, name)
+		}
+	}
+
+	check("host", startup.Host != cfg.Host ||
+		!slices.Equal(startup.HostAliases, cfg.HostAliases) ||
+		startup.PublicPort != cfg.PublicPort)
+	check("listen", startup.TunnelAddr != cfg.TunnelAddr || startup.HTTPAddr != cfg.HTTPAddr || startup.QUICAddr != cfg.QUICAddr)
+	check("admin", startup.AdminAddr != cfg.AdminAddr)
+	check("tls", (startup.TLSCertFile == "") != (cfg.TLSCertFile == ""))
+	check("public listener timeouts and limits", startup.ReadHeaderTimeout != cfg.ReadHeaderTimeout ||
+		startup.ReadTimeout != cfg.ReadTimeout ||
+		startup.IdleTimeout != cfg.IdleTimeout ||
+		startup.MaxHeaderBytes != cfg.MaxHeaderBytes)
+	// QUIC streams get their settings before the handshake.
+	check("QUIC session settings", startup.QUICAddr != "" && startup.QUICSessionConfig() != cfg.QUICSessionConfig())
+	check("log", startup.LogFormat != cfg.LogFormat ||
+		startup.OTLPEndpoint != cfg.OTLPEndpoint ||
+		startup.AuditLog != cfg.AuditLog ||
+		startup.AuditLogMaxBytes != cfg.AuditLogMaxBytes ||
+		startup.AuditLogMaxBackups != cfg.AuditLogMaxBackups)
+	return changed
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AYM1607/godig/pkg/audit"
+	"github.com/AYM1607/godig/pkg/auth"
+)
+
+// defaultTokenTTL is how long minted tokens are valid by default.
+const defaultTokenTTL = 24 * time.Hour
+
+// stringsFlag collects repeated string flags.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	if s == "" {
+		return fmt.Errorf("value must not be empty")
+	}
+	*f = append(*f, s)
+	return nil
+}
+
+func handleTokenCommand() {
+	if len(os.Args) < 3 || os.Args[2] != "create" {
+		fmt.Println("Usage: godig-server token create [flags]")
+		fmt.Println("\nMints a token for the jwt auth backend, signed with auth.jwt_secret")
+		fmt.Println("from --config or GODIG_JWT_SECRET.")
+		fmt.Println("Clients present it as their API key.")
+		fmt.Println("With auth.backend=jwt, tokens replace auth.api_key, which is no longer accepted.")
+		os.Exit(1)
+	}
+
+	fs := flag.NewFlagSet("token create", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("GODIG_CONFIG"), "Path to the server configuration file holding auth.jwt_secret")
+	subject := fs.String("subject", "", "Who the token is for (required)")
+	ttl := fs.Duration("ttl", defaultTokenTTL, "How long the token is valid")
+	var tunnels stringsFlag
+	fs.Var(&tunnels, "tunnel", "Tunnel ID the token may register, glob patterns allowed (repeatable, any when unset)")
+	fs.Parse(os.Args[3:])
+
+	if *subject == "" {
+		log.Fatalln("--subject is required")
+	}
+	// Token times have a one second resolution.
+	if *ttl < time.Second {
+		log.Fatalln("--ttl must be at least 1s")
+	}
+	cfg := defaultServerConfig()
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if err := cfg.loadEnv(cfg.settings()); err != nil {
+		log.Fatalln(err)
+	}
+	if len(cfg.JWTSecret) < minJWTSecretBytes {
+		log.Fatalf("auth.jwt_secret (GODIG_JWT_SECRET) must be at least %d bytes\n", minJWTSecretBytes)
+	}
+
+	// Stdout only carries the token.
+	var sink audit.Sink
+	if cfg.AuditLog == "-" {
+		sink = audit.NewJSONSink(os.Stderr)
+	} else {
+		var err error
+		if sink, err = newAuditSink(cfg); err != nil {
+			log.Fatalln(err)
+		}
+		defer closeAuditSink(sink)
+	}
+
+	token, expiresAt, err := createToken(cfg, *subject, tunnels, *ttl, sink)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fmt.Fprintf(os.Stderr, "Token for %s, expires %s\n", *subject, expiresAt.Format(time.RFC3339))
+	fmt.Println(token)
+}
+
+// createToken signs a token for subject and records it in the audit log, if
+// sink isn't nil. A token that can't be recorded isn't returned.
+func createToken(cfg ServerConfig, subject string, tunnels []string, ttl time.Duration, sink audit.Sink) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	token, err := auth.SignJWT([]byte(cfg.JWTSecret), auth.Claims{
+		Subject:   subject,
+		Tunnels:   tunnels,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	if sink != nil {
+		err := sink.Record(audit.Event{
+			Time:      now,
+			Type:      audit.AdminAction,
+			Action:    "create_token",
+			Subject:   subject,
+			Tunnels:   tunnels,
+			ExpiresAt: expiresAt.Truncate(time.Second),
+		})
+		if err != nil {
+			return "", time.Time{}, fmt.Errorf("failed to record the token in the audit log: %w", err)
+		}
+	}
+	return token, expiresAt, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AYM1607/godig/pkg/audit"
+	"github.com/AYM1607/godig/pkg/auth"
+)
+
+// auditRecorder is an audit.Sink keeping the events in memory, failing with
+// err when set.
+type auditRecorder struct {
+	events []audit.Event
+	err    error
+}
+
+func (r *auditRecorder) Record(e audit.Event) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.events = append(r.events, e)
+	return nil
+}
+
+func TestCreateToken(t *testing.T) {
+	cfg := defaultServerConfig()
+	cfg.JWTSecret = strings.Repeat("s", minJWTSecretBytes)
+	sink := &auditRecorder{}
+
+	token, expiresAt, err := createToken(cfg, "alice", []string{"alice-*"}, time.Hour, sink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := auth.ParseJWT([]byte(cfg.JWTSecret), token, time.Now())
+	if err != nil {
+		t.Fatalf("failed to parse token: %v", err)
+	}
+	if claims.Subject != "alice" || claims.ExpiresAt != expiresAt.Unix() {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	if len(sink.events) != 1 {
+		t.Fatalf("expected one audit event, got %d", len(sink.events))
+	}
+	e := sink.events[0]
+	if e.Type != audit.AdminAction || e.Action != "create_token" || e.Subject != "alice" {
+		t.Errorf("unexpected audit event %+v", e)
+	}
+	if !slices.Equal(e.Tunnels, []string{"alice-*"}) || e.ExpiresAt.Unix() != claims.ExpiresAt {
+		t.Errorf("expected the token scope and expiry in the audit event, got %+v", e)
+	}
+
+	// Tokens missing from the audit log aren't handed out.
+	sink.err = errors.New("disk full")
+	if token, _, err := createToken(cfg, "bob", nil, time.Hour, sink); err == nil || token != "" {
+		t.Errorf("expected the token to be refused when it can't be recorded, got %q and %v", token, err)
+	}
+}
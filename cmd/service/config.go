@@ -1,50 +1,77 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/exec"
+	"path/filepath"
 
 	"github.com/AYM1607/godig/pkg/config"
 )
 
+func printConfigUsage() {
+	fmt.Println("Usage: godig-service config [--profile <name>] <command>")
+	fmt.Println("\nCommands:")
+	fmt.Println("  set <key> <value>                 Set a configuration value")
+	fmt.Println("  get [--show-secrets] <key>        Get a configuration value")
+	fmt.Println("  unset <key>                       Remove a configuration value")
+	fmt.Println("  list [--show-secrets]             List the configuration values")
+	fmt.Println("  edit                              Edit the configuration file with $VISUAL or $EDITOR")
+	fmt.Println("  validate                          Check the configuration file")
+	fmt.Println("  profile list                      List the profiles")
+	fmt.Println("  profile create <name>             Create a profile")
+	fmt.Println("  profile delete <name>             Delete a profile")
+	fmt.Println("  profile use <name>                Make a profile the current one")
+	fmt.Println("\nKeys:")
+	for _, setting := range config.Schema() {
+		fmt.Printf("  %-28s %s\n", setting.Key, setting.Description)
+	}
+	fmt.Printf("\nSettings missing from a profile are taken from the %s one.\n", config.DefaultProfile)
+}
+
 func handleConfigCommand() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: godig-service config <command>")
-		fmt.Println("\nCommands:")
-		fmt.Println("  set <key> <value>  Set a configuration value")
-		fmt.Println("  get <key>          Get a configuration value")
-		fmt.Println("\nKeys:")
-		fmt.Printf("  %s      API key for server authentication\n", config.KeyAPIKey)
-		fmt.Printf("  %s        Server address (e.g., godig.xyz:8080)\n", config.KeyServer)
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	profile := fs.String("profile", os.Getenv("GODIG_PROFILE"), "Profile to read or update (defaults to GODIG_PROFILE, then the current profile)")
+	fs.Usage = printConfigUsage
+	fs.Parse(os.Args[2:])
+	args := fs.Args()
+
+	if len(args) < 1 {
+		printConfigUsage()
 		os.Exit(1)
 	}
 
-	command := os.Args[2]
+	command := args[0]
 
 	switch command {
 	case "set":
-		if len(os.Args) < 5 {
-			fmt.Println("Usage: godig-service config set <key> <value>")
+		if len(args) < 3 {
+			fmt.Println("Usage: godig-service config [--profile <name>] set <key> <value>")
 			os.Exit(1)
 		}
-		key := config.ConfigKey(os.Args[3])
-		value := os.Args[4]
+		key := config.ConfigKey(args[1])
+		value := args[2]
 
-		if err := config.SetConfigValue(key, value); err != nil {
+		if err := config.SetConfigValue(*profile, key, value); err != nil {
 			log.Fatalf("Failed to set config: %v\n", err)
 		}
 
-		fmt.Printf("Configuration updated: %s = %s\n", key, value)
+		setting, _ := config.LookupSetting(key)
+		fmt.Printf("Configuration updated: %s = %s\n", key, setting.Redact(value))
 
 	case "get":
-		if len(os.Args) < 4 {
-			fmt.Println("Usage: godig-service config get <key>")
+		getFlags := flag.NewFlagSet("config get", flag.ExitOnError)
+		showSecrets := getFlags.Bool("show-secrets", false, "Print secrets in full")
+		getFlags.Parse(args[1:])
+		if getFlags.NArg() < 1 {
+			fmt.Println("Usage: godig-service config [--profile <name>] get [--show-secrets] <key>")
 			os.Exit(1)
 		}
-		key := config.ConfigKey(os.Args[3])
+		key := config.ConfigKey(getFlags.Arg(0))
 
-		value, err := config.GetConfigValue(key)
+		value, err := config.GetConfigValue(*profile, key)
 		if err != nil {
 			log.Fatalf("Failed to get config: %v\n", err)
 		}
@@ -52,12 +79,196 @@ func handleConfigCommand() {
 		if value == "" {
 			fmt.Printf("%s is not set\n", key)
 		} else {
-			fmt.Printf("%s = %s\n", key, value)
+			fmt.Printf("%s = %s\n", key, redact(key, value, *showSecrets))
+		}
+
+	case "unset":
+		if len(args) < 2 {
+			fmt.Println("Usage: godig-service config [--profile <name>] unset <key>")
+			os.Exit(1)
+		}
+		key := config.ConfigKey(args[1])
+
+		if err := config.UnsetConfigValue(*profile, key); err != nil {
+			log.Fatalf("Failed to unset config: %v\n", err)
+		}
+
+		fmt.Printf("Configuration updated: %s unset\n", key)
+
+	case "list":
+		listFlags := flag.NewFlagSet("config list", flag.ExitOnError)
+		showSecrets := listFlags.Bool("show-secrets", false, "Print secrets in full")
+		listFlags.Parse(args[1:])
+		listConfig(*profile, *showSecrets)
+
+	case "edit":
+		editConfig()
+
+	case "validate":
+		path, err := config.Path()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			fmt.Printf("%s doesn't exist, the defaults are used\n", path)
+			return
+		}
+		if err := config.ValidateFile(path); err != nil {
+			fmt.Printf("%s is invalid:\n%v\n", path, err)
+			os.Exit(1)
 		}
+		fmt.Printf("%s is valid\n", path)
+
+	case "profile":
+		handleProfileCommand(args[1:])
 
 	default:
 		fmt.Printf("Unknown command: %s\n", command)
-		fmt.Println("Valid commands: set, get")
+		fmt.Println("Valid commands: set, get, unset, list, edit, validate, profile")
+		os.Exit(1)
+	}
+}
+
+// redact returns value with its secrets masked, unless showSecrets is set.
+func redact(key config.ConfigKey, value string, showSecrets bool) string {
+	if showSecrets {
+		return value
+	}
+	setting, err := config.LookupSetting(key)
+	if err != nil {
+		return value
+	}
+	return setting.Redact(value)
+}
+
+// listConfig prints every setting of profile, including those taken from the
+// default profile.
+func listConfig(profile string, showSecrets bool) {
+	globalConfig, err := config.LoadGlobalConfig()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v\n", err)
+	}
+	effective, err := globalConfig.Profile(profile)
+	if err != nil {
+		log.Fatalf("Failed to load config profile: %v\n", err)
+	}
+	own, err := globalConfig.ProfileSettings(profile)
+	if err != nil {
+		log.Fatalf("Failed to load config profile: %v\n", err)
+	}
+	name := globalConfig.ProfileName(profile)
+
+	fmt.Printf("Profile: %s\n\n", name)
+	for _, setting := range config.Schema() {
+		value := setting.Get(&effective)
+		if value == "" {
+			fmt.Printf("  %-28s (not set)\n", setting.Key)
+			continue
+		}
+
+		origin := ""
+		if setting.Get(own) == "" {
+			origin = fmt.Sprintf(" (from %s)", config.DefaultProfile)
+		}
+		fmt.Printf("  %-28s %s%s\n", setting.Key, redact(setting.Key, value, showSecrets), origin)
+	}
+}
+
+// editConfig opens a copy of the configuration file in the user's editor and
+// saves it back only if it's valid.
+func editConfig() {
+	path, err := config.Path()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Fatalf("Failed to read config: %v\n", err)
+	}
+
+	edited, err := os.CreateTemp("", "godig-config-*.yaml")
+	if err != nil {
+		log.Fatalf("Failed to edit config: %v\n", err)
+	}
+	defer edited.Close()
+	if _, err := edited.Write(data); err != nil {
+		log.Fatalf("Failed to edit config: %v\n", err)
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	// The editor may come with arguments, e.g. "code --wait".
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", edited.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Fatalf("Editor failed, changes kept in %s: %v\n", edited.Name(), err)
+	}
+
+	if err := config.ValidateFile(edited.Name()); err != nil {
+		fmt.Printf("The configuration is invalid, changes kept in %s:\n%v\n", edited.Name(), err)
+		os.Exit(1)
+	}
+	data, err = os.ReadFile(edited.Name())
+	if err != nil {
+		log.Fatalf("Failed to read edited config: %v\n", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Fatalf("Failed to save config: %v\n", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		log.Fatalf("Failed to save config: %v\n", err)
+	}
+	os.Remove(edited.Name())
+	fmt.Printf("Configuration saved to %s\n", path)
+}
+
+func handleProfileCommand(args []string) {
+	if len(args) < 1 || (args[0] != "list" && len(args) < 2) {
+		fmt.Println("Usage: godig-service config profile <list|create|delete|use> [name]")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "list":
+		globalConfig, err := config.LoadGlobalConfig()
+		if err != nil {
+			log.Fatalf("Failed to load config: %v\n", err)
+		}
+		current := globalConfig.ProfileName("")
+		for _, name := range globalConfig.ProfileNames() {
+			marker := " "
+			if name == current {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, name)
+		}
+
+	case "create":
+		if err := config.CreateProfile(args[1]); err != nil {
+			log.Fatalf("Failed to create profile: %v\n", err)
+		}
+		fmt.Printf("Profile %s created\n", args[1])
+
+	case "delete":
+		if err := config.DeleteProfile(args[1]); err != nil {
+			log.Fatalf("Failed to delete profile: %v\n", err)
+		}
+		fmt.Printf("Profile %s deleted\n", args[1])
+
+	case "use":
+		if err := config.UseProfile(args[1]); err != nil {
+			log.Fatalf("Failed to switch profile: %v\n", err)
+		}
+		fmt.Printf("Now using profile %s\n", args[1])
+
+	default:
+		fmt.Printf("Unknown profile command: %s\n", args[0])
+		fmt.Println("Valid commands: list, create, delete, use")
 		os.Exit(1)
 	}
 }
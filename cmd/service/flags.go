@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/AYM1607/godig/pkg/headers"
+	"github.com/AYM1607/godig/types"
+)
+
+// headerMapFlag collects repeated "Name: value" flags.
+type headerMapFlag map[string]string
+
+func (f headerMapFlag) String() string {
+	parts := make([]string, 0, len(f))
+	for name, value := range f {
+		parts = append(parts, fmt.Sprintf("%s: %s", name, value))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (f headerMapFlag) Set(s string) error {
+	name, value, err := headers.ParseHeader(s)
+	if err != nil {
+		return err
+	}
+	f[name] = value
+	return nil
+}
+
+// headerValuesFlag collects repeated "Name: value" flags, keeping every value
+// given for a header.
+type headerValuesFlag map[string]types.HeaderValues
+
+func (f headerValuesFlag) String() string {
+	parts := make([]string, 0, len(f))
+	for name, values := range f {
+		for _, value := range values {
+			parts = append(parts, fmt.Sprintf("%s: %s", name, value))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (f headerValuesFlag) Set(s string) error {
+	name, value, err := headers.ParseHeader(s)
+	if err != nil {
+		return err
+	}
+	f[name] = append(f[name], value)
+	return nil
+}
+
+// headerNamesFlag collects repeated header name flags.
+type headerNamesFlag []string
+
+func (f *headerNamesFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *headerNamesFlag) Set(s string) error {
+	name := strings.TrimSpace(s)
+	if name == "" {
+		return fmt.Errorf("header name must not be empty")
+	}
+	*f = append(*f, http.CanonicalHeaderKey(name))
+	return nil
+}
+
+// isFlagSet reports whether the flag name was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
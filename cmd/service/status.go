@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/AYM1607/godig/pkg/tunnel"
+	"github.com/AYM1607/godig/types"
+)
+
+// defaultStatusAddr is where a running godig-service reports its status.
+const defaultStatusAddr = "localhost:4040"
+
+// serveStatus serves the status endpoint of client on addr. Failing to listen
+// doesn't stop the tunnel, another service may already be using the address.
+func serveStatus(addr string, client *tunnel.TunnelClient) {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           client.StatusHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("Status available at http://%s/status", addr)
+	if err := server.ListenAndServe(); err != nil {
+		log.Printf("Failed to serve status on %s: %v", addr, err)
+	}
+}
+
+func handleStatusCommand() {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	statusAddr := fs.String("status-addr", defaultStatusAddr, "Status address of the running godig-service")
+	jsonOutput := fs.Bool("json", false, "Print the raw JSON status")
+	fs.Parse(os.Args[2:])
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://%s/status", *statusAddr))
+	if err != nil {
+		log.Fatalf("Failed to reach godig-service at %s, is it running? %v\n", *statusAddr, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Unexpected status response: %s\n", resp.Status)
+	}
+
+	var status types.TunnelStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		log.Fatalf("Failed to decode status: %v\n", err)
+	}
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(status)
+		return
+	}
+
+	fmt.Printf("State:       %s\n", status.State)
+	fmt.Printf("Tunnel URL:  %s\n", status.URL)
+	fmt.Printf("Server:      %s\n", status.Server)
+	if status.Transport != "" {
+		fmt.Printf("Transport:   %s\n", status.Transport)
+	}
+	fmt.Printf("Uptime:      %s\n", status.Uptime)
+	if status.ConnectedAt != nil {
+		fmt.Printf("Connected:   %s\n", status.ConnectedAt.Local().Format(time.RFC3339))
+	}
+	if status.LastHeartbeat != nil {
+		fmt.Printf("Heartbeat:   %s (rtt %s)\n", status.LastHeartbeat.Local().Format(time.RFC3339), status.HeartbeatRTT)
+	}
+	fmt.Printf("Reconnects:  %d\n", status.Reconnects)
+	if status.LastError != "" {
+		fmt.Printf("Last error:  %s (%s)\n", status.LastError, status.LastErrorAt.Local().Format(time.RFC3339))
+	}
+}
@@ -8,7 +8,7 @@ buildGoModule rec {
 
   subPackages = [ "cmd/service" ];
 
-  vendorHash = "sha256-rG5eCE2si6GRboO+Skuc+1O5lxkjDNte8eLCG4vcFJs=";
+  vendorHash = "sha256-bPTrNATRg2/wEfUE6kIN4XsVnNoqlOdMTZgeaHqIHRk=";
 
   meta = with lib; {
     description = "Godig tunnel client - connects to server and exposes local services";
@@ -0,0 +1,127 @@
+// Package accesslog assigns request IDs and writes structured access logs for
+// the requests served through tunnels.
+package accesslog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Log formats accepted by NewHandler.
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// NewHandler returns a slog handler writing access logs to w in the given
+// format. Any other slog.Handler can be used with Handler instead.
+func NewHandler(w io.Writer, format string) (slog.Handler, error) {
+	switch format {
+	case "", FormatJSON:
+		return slog.NewJSONHandler(w, nil), nil
+	case FormatText:
+		return slog.NewTextHandler(w, nil), nil
+	}
+	return nil, fmt.Errorf("unsupported log format %q, use %s or %s", format, FormatJSON, FormatText)
+}
+
+// Handler wraps h, giving every request an ID and logging it once it has been
+// served. tunnelID returns the tunnel a request is for. clientIP returns the
+// address of the public client, when nil the peer address is used.
+func Handler(h http.Handler, logger *slog.Logger, tunnelID, clientIP func(*http.Request) string) http.Handler {
+	if clientIP == nil {
+		clientIP = peerIP
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := ensureRequestID(r)
+		r = r.WithContext(WithRequestID(r.Context(), id))
+
+		rec := &recorder{ResponseWriter: w, requestID: id}
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			// Write the implicit response now so it carries the ID.
+			rec.WriteHeader(http.StatusOK)
+		}
+
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("tunnel_id", tunnelID(r)),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Int64("bytes", rec.bytes),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", clientIP(r)),
+		}
+		// Link the entry to the trace when the request is traced.
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+		}
+		logger.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+	})
+}
+
+// peerIP returns the IP of the connection peer.
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// recorder captures the status and size of a response, and sends the request
+// ID back to the client.
+type recorder struct {
+	http.ResponseWriter
+	requestID string
+	status    int
+	bytes     int64
+}
+
+func (w *recorder) WriteHeader(code int) {
+	// Informational responses can be written many times before the final one.
+	if w.status < http.StatusOK {
+		w.status = code
+		// The response from a tunnel may already carry the ID.
+		if code >= http.StatusOK && w.Header().Get(RequestIDHeader) == "" {
+			w.Header().Set(RequestIDHeader, w.requestID)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recorder) Write(b []byte) (int, error) {
+	if w.status < http.StatusOK {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Hijack records upgraded connections as switching protocols, their traffic
+// is not counted.
+func (w *recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+// Unwrap allows http.ResponseController to reach the flushing support of the
+// wrapped writer.
+func (w *recorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
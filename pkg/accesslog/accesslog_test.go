@@ -0,0 +1,122 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	var logs bytes.Buffer
+	var forwardedID string
+	handler := Handler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			forwardedID = r.Header.Get(RequestIDHeader)
+			if RequestID(r.Context()) != forwardedID {
+				t.Errorf("expected context request ID %q, got %q", forwardedID, RequestID(r.Context()))
+			}
+			w.WriteHeader(http.StatusCreated)
+			io.WriteString(w, "hello")
+		}),
+		slog.New(slog.NewJSONHandler(&logs, nil)),
+		func(*http.Request) string { return "abcde" },
+		nil,
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "http://abcde.godig.xyz/items?page=2", nil)
+	req.RemoteAddr = "203.0.113.7:51000"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if len(forwardedID) != 32 {
+		t.Errorf("expected a generated request ID, got %q", forwardedID)
+	}
+	if got := rec.Header().Get(RequestIDHeader); got != forwardedID {
+		t.Errorf("expected response request ID %q, got %q", forwardedID, got)
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to decode log entry %q: %v", logs.String(), err)
+	}
+	expected := map[string]any{
+		"msg":        "request",
+		"request_id": forwardedID,
+		"tunnel_id":  "abcde",
+		"method":     "POST",
+		"path":       "/items",
+		"status":     float64(http.StatusCreated),
+		"bytes":      float64(5),
+		"client_ip":  "203.0.113.7",
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("expected %s %v, got %v", key, value, entry[key])
+		}
+	}
+	if _, ok := entry["duration_ms"]; !ok {
+		t.Error("expected duration_ms in log entry")
+	}
+}
+
+func TestHandler_RequestID(t *testing.T) {
+	tests := []struct {
+		name       string
+		incoming   string
+		responseID string
+		expectKept bool
+	}{
+		{name: "valid incoming ID", incoming: "req-123_abc", expectKept: true},
+		{name: "invalid incoming ID", incoming: "bad id\r\nX-Injected: 1"},
+		{name: "too long incoming ID", incoming: strings.Repeat("a", maxRequestIDLength+1)},
+		{name: "ID set by the upstream response", incoming: "req-123", responseID: "req-123", expectKept: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var forwardedID string
+			handler := Handler(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					forwardedID = r.Header.Get(RequestIDHeader)
+					if tt.responseID != "" {
+						w.Header().Add(RequestIDHeader, tt.responseID)
+					}
+				}),
+				slog.New(slog.NewJSONHandler(io.Discard, nil)),
+				func(*http.Request) string { return "" },
+				nil,
+			)
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(RequestIDHeader, tt.incoming)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if tt.expectKept && forwardedID != tt.incoming {
+				t.Errorf("expected request ID %q to be kept, got %q", tt.incoming, forwardedID)
+			}
+			if !tt.expectKept && (forwardedID == tt.incoming || !validRequestID(forwardedID)) {
+				t.Errorf("expected a new request ID, got %q", forwardedID)
+			}
+			if values := rec.Header().Values(RequestIDHeader); len(values) != 1 || values[0] != forwardedID {
+				t.Errorf("expected response request ID %q once, got %v", forwardedID, values)
+			}
+		})
+	}
+}
+
+func TestNewHandler(t *testing.T) {
+	for _, format := range []string{"", FormatJSON, FormatText} {
+		if _, err := NewHandler(io.Discard, format); err != nil {
+			t.Errorf("unexpected error for format %q: %v", format, err)
+		}
+	}
+	if _, err := NewHandler(io.Discard, "xml"); err == nil {
+		t.Error("expected error but got none")
+	}
+}
@@ -0,0 +1,63 @@
+package accesslog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader carries the request ID from the public client, through the
+// tunnel, to the local service and back in the response.
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength bounds the incoming request IDs that are honored.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, or an empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random request ID.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	// crypto/rand.Read never returns an error.
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ensureRequestID returns the ID sent with r, generating one when it is
+// missing or invalid. The header is updated so the ID is forwarded.
+func ensureRequestID(r *http.Request) string {
+	id := r.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		id = NewRequestID()
+		r.Header.Set(RequestIDHeader, id)
+	}
+	return id
+}
+
+// validRequestID reports whether id is safe to log and forward.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
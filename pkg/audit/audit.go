@@ -0,0 +1,72 @@
+// Package audit records who exposed which tunnel and when. Events are
+// appended as JSON lines to a Sink, usually a File rotated by size.
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	// HandshakeFailed is a tunnel refused during its handshake.
+	HandshakeFailed = "handshake_failed"
+	// TunnelRegistered is a tunnel accepted and routable, with the identity
+	// it authenticated as.
+	TunnelRegistered = "tunnel_registered"
+	// SessionReplaced is a tunnel taking over the ID of a connected one.
+	SessionReplaced = "session_replaced"
+	// TunnelDisconnected is the session of a registered tunnel ending.
+	TunnelDisconnected = "tunnel_disconnected"
+	// PublicAuthFailed is a public request refused for a wrong bearer token.
+	PublicAuthFailed = "public_auth_failed"
+	// AdminAction is an operator changing the server, e.g. reloading its
+	// configuration.
+	AdminAction = "admin_action"
+)
+
+// Event is an entry of the audit log.
+type Event struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"event"`
+	// TunnelID is the tunnel the event is about, if any.
+	TunnelID string `json:"tunnelID,omitempty"`
+	// Subject is who the tunnel authenticated as, or the operator of an admin
+	// action.
+	Subject    string `json:"subject,omitempty"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	// Public is set for tunnels reachable without a bearer token.
+	Public bool `json:"public,omitempty"`
+	// Action names what an admin action did.
+	Action string `json:"action,omitempty"`
+	// Tunnels and ExpiresAt are the scope and expiry of a created token.
+	Tunnels   []string  `json:"tunnels,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt,omitzero"`
+	// Reason explains failures and replacements.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Sink stores audit events. Record is called concurrently.
+type Sink interface {
+	Record(e Event) error
+}
+
+// jsonSink writes events as JSON lines to a writer.
+type jsonSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONSink returns a Sink writing the events to w, one JSON object per
+// line.
+func NewJSONSink(w io.Writer) Sink {
+	return &jsonSink{enc: json.NewEncoder(w)}
+}
+
+func (s *jsonSink) Record(e Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(e)
+}
@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// File is a Sink appending the events as JSON lines to a file. Once the file
+// reaches MaxBytes it's renamed to <path>.1, shifting older files up to
+// <path>.<maxBackups>, and a new one is started.
+type File struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+// OpenFile opens the audit log at path, creating it if needed. A zero
+// maxBytes never rotates it, rotated files beyond maxBackups are removed.
+func OpenFile(path string, maxBytes int64, maxBackups int) (*File, error) {
+	f := &File{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *File) Record(e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	var rotateErr error
+	if f.maxBytes > 0 && f.size > 0 && f.size+int64(len(line)) > f.maxBytes {
+		// A failed rotation keeps appending to the current file, events
+		// aren't dropped for it.
+		if rotateErr = f.rotate(); f.file == nil {
+			return rotateErr
+		}
+	}
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	return errors.Join(rotateErr, err)
+}
+
+// rotate moves the current file to the first backup and opens a new one.
+func (f *File) rotate() error {
+	f.file.Close()
+	f.file = nil
+
+	var err error
+	if f.maxBackups <= 0 {
+		err = os.Remove(f.path)
+	} else {
+		os.Remove(f.backup(f.maxBackups))
+		for i := f.maxBackups - 1; i >= 1 && err == nil; i-- {
+			if err = os.Rename(f.backup(i), f.backup(i+1)); os.IsNotExist(err) {
+				err = nil
+			}
+		}
+		if err == nil {
+			err = os.Rename(f.path, f.backup(1))
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	return errors.Join(err, f.open())
+}
+
+func (f *File) backup(n int) string {
+	return fmt.Sprintf("%s.%d", f.path, n)
+}
+
+// Reopen opens the file at path again, so events go to a new file once the
+// current one was moved away, e.g. by logrotate. The current file is kept if
+// the new one can't be opened.
+func (f *File) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	current := f.file
+	if err := f.open(); err != nil {
+		return err
+	}
+	if current != nil {
+		return current.Close()
+	}
+	return nil
+}
+
+// Close closes the file, later events fail to be recorded.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
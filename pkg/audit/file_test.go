@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFile_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := OpenFile(path, 200, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Each event is about 100 bytes, two fit in a file.
+	for i := range 7 {
+		if err := f.Record(Event{Time: time.Unix(int64(i), 0).UTC(), Type: TunnelRegistered, TunnelID: "abcde", Subject: "alice"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		events := readEvents(t, name)
+		if len(events) == 0 || len(events) > 2 {
+			t.Errorf("expected 1 or 2 events in %s, got %d", name, len(events))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected at most 2 rotated files, got %v", err)
+	}
+	if events := readEvents(t, path); events[len(events)-1].Time.Unix() != 6 {
+		t.Errorf("expected the last event in the current file, got %+v", events)
+	}
+}
+
+func TestFile_Append(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	for range 2 {
+		f, err := OpenFile(path, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Record(Event{Type: HandshakeFailed, Reason: "unauthorized"}); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+
+	if events := readEvents(t, path); len(events) != 2 || events[1].Reason != "unauthorized" {
+		t.Errorf("expected the events to be appended, got %+v", events)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the audit log to be private, got %v", info.Mode().Perm())
+	}
+}
+
+func TestFile_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	f, err := OpenFile(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.Record(Event{Type: TunnelRegistered, TunnelID: "before"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Moved away like logrotate does.
+	if err := os.Rename(path, path+".old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reopen(); err != nil {
+		t.Fatalf("failed to reopen: %v", err)
+	}
+	if err := f.Record(Event{Type: TunnelRegistered, TunnelID: "after"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if events := readEvents(t, path+".old"); len(events) != 1 || events[0].TunnelID != "before" {
+		t.Errorf("expected the moved file to keep the old events, got %+v", events)
+	}
+	if events := readEvents(t, path); len(events) != 1 || events[0].TunnelID != "after" {
+		t.Errorf("expected the new events in a new file, got %+v", events)
+	}
+
+	f.Close()
+	if err := f.Reopen(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected a closed file not to be reopened, got %v", err)
+	}
+}
+
+func readEvents(t *testing.T, path string) []Event {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"time"
+
+	"github.com/AYM1607/godig/types"
+)
+
+// ErrUnauthorized is wrapped by the errors of authenticators refusing the
+// credentials of a tunnel, as opposed to failing to check them.
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Request holds what a tunnel presents when connecting to the server.
+type Request struct {
+	Handshake  types.HandshakeMessage
+	RemoteAddr net.Addr
+	// TLS is the state of the TLS connection carrying the tunnel, nil when
+	// there is none.
+	TLS *tls.ConnectionState
+}
+
+// Identity is who a tunnel connected as and what it's allowed to do.
+type Identity struct {
+	// Subject names the holder of the credentials, e.g. the name of a key or
+	// the subject of a token.
+	Subject string `json:"subject"`
+	// TunnelIDs lists the tunnel IDs the identity may register, as
+	// path.Match patterns. Any tunnel ID is allowed when empty.
+	TunnelIDs []string `json:"tunnelIDs,omitempty"`
+	// ExpiresAt is when the credentials expire, zero if they don't.
+	ExpiresAt time.Time `json:"expiresAt,omitzero"`
+}
+
+// CanRegister reports whether the identity may register tunnelID.
+func (id *Identity) CanRegister(tunnelID string) bool {
+	if len(id.TunnelIDs) == 0 {
+		return true
+	}
+	for _, pattern := range id.TunnelIDs {
+		if ok, _ := path.Match(pattern, tunnelID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator checks the credentials of connecting tunnels.
+type Authenticator interface {
+	// Authenticate returns the identity of the tunnel, or an error when the
+	// tunnel can't connect.
+	Authenticate(ctx context.Context, req Request) (*Identity, error)
+}
+
+// Func adapts a function to an Authenticator.
+type Func func(ctx context.Context, req Request) (*Identity, error)
+
+func (f Func) Authenticate(ctx context.Context, req Request) (*Identity, error) {
+	return f(ctx, req)
+}
+
+// StaticKey returns an Authenticator accepting the tunnels presenting key as
+// their API key, all with the same unrestricted identity.
+func StaticKey(key string) Authenticator {
+	return Func(func(ctx context.Context, req Request) (*Identity, error) {
+		if !equalKeys(req.Handshake.APIKey, key) {
+			return nil, fmt.Errorf("%w: invalid API key", ErrUnauthorized)
+		}
+		return &Identity{Subject: "api-key"}, nil
+	})
+}
+
+// equalKeys compares keys in constant time.
+func equalKeys(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
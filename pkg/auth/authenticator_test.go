@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AYM1607/godig/types"
+)
+
+func request(apiKey string) Request {
+	return Request{Handshake: types.HandshakeMessage{TunnelID: "abcde", APIKey: apiKey}}
+}
+
+func TestStaticKey(t *testing.T) {
+	authenticator := StaticKey("secret")
+
+	identity, err := authenticator.Authenticate(context.Background(), request("secret"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !identity.CanRegister("anything") {
+		t.Error("expected the static key to register any tunnel")
+	}
+
+	if _, err := authenticator.Authenticate(context.Background(), request("wrong")); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+}
+
+func TestIdentity_CanRegister(t *testing.T) {
+	identity := &Identity{TunnelIDs: []string{"alice", "alice-*"}}
+
+	for tunnelID, expected := range map[string]bool{
+		"alice":     true,
+		"alice-api": true,
+		"bob":       false,
+		"alicex":    false,
+	} {
+		if got := identity.CanRegister(tunnelID); got != expected {
+			t.Errorf("CanRegister(%q): expected %v, got %v", tunnelID, expected, got)
+		}
+	}
+}
+
+func TestKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`keys:
+  - name: alice
+    key: alice-key
+    tunnels: ["alice-*"]
+  - name: ci
+    key: ci-key
+`)
+
+	keyFile, err := LoadKeyFile(path)
+	if err != nil {
+		t.Fatalf("failed to load key file: %v", err)
+	}
+
+	identity, err := keyFile.Authenticate(context.Background(), request("alice-key"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if identity.Subject != "alice" || identity.CanRegister("bob") || !identity.CanRegister("alice-1") {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+	if _, err := keyFile.Authenticate(context.Background(), request("bob-key")); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+
+	// Invalid files are refused and the previous keys kept.
+	write("keys:\n  - name: alice\n")
+	if err := keyFile.Reload(); err == nil {
+		t.Error("expected an error for a key without a value")
+	}
+	if _, err := keyFile.Authenticate(context.Background(), request("ci-key")); err != nil {
+		t.Errorf("expected the previous keys to be kept, got %v", err)
+	}
+
+	write("keys:\n  - name: bob\n    key: bob-key\n")
+	if err := keyFile.Reload(); err != nil {
+		t.Fatalf("failed to reload key file: %v", err)
+	}
+	if _, err := keyFile.Authenticate(context.Background(), request("bob-key")); err != nil {
+		t.Errorf("expected the new key to be accepted, got %v", err)
+	}
+	if _, err := keyFile.Authenticate(context.Background(), request("ci-key")); err == nil {
+		t.Error("expected the removed key to be refused")
+	}
+}
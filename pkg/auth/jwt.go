@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+// jwtLeeway is the clock skew tolerated when checking the validity period of
+// a token.
+const jwtLeeway = 30 * time.Second
+
+// Claims are the JWT claims read by the JWT authenticator. Times are Unix
+// timestamps, zero when unset. Tokens must expire, ExpiresAt is required.
+type Claims struct {
+	Subject string `json:"sub,omitempty"`
+	// Tunnels lists the tunnel IDs the token may register, as path.Match
+	// patterns. Any tunnel ID is allowed when empty.
+	Tunnels   []string `json:"tunnels,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// jwtAlgorithms are the supported signing algorithms, all HMAC based.
+var jwtAlgorithms = map[string]func() hash.Hash{
+	"HS256": sha256.New,
+	"HS384": sha512.New384,
+	"HS512": sha512.New,
+}
+
+// SignJWT returns a token with claims signed with HS256.
+func SignJWT(secret []byte, claims Claims) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(jwtSignature(sha256.New, secret, signed)), nil
+}
+
+// ParseJWT verifies the signature and validity period of token and returns
+// its claims. Tokens without an expiry are refused.
+func ParseJWT(secret []byte, token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	newHash, ok := jwtAlgorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %w", err)
+	}
+	if !hmac.Equal(signature, jwtSignature(newHash, secret, parts[0]+"."+parts[1])) {
+		return nil, errors.New("invalid token signature")
+	}
+
+	var claims Claims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if claims.ExpiresAt == 0 {
+		return nil, errors.New("token has no expiry")
+	}
+	if claims.IssuedAt != 0 && claims.ExpiresAt <= claims.IssuedAt {
+		return nil, errors.New("token expires before it was issued")
+	}
+	if now.After(time.Unix(claims.ExpiresAt, 0).Add(jwtLeeway)) {
+		return nil, errors.New("token expired")
+	}
+	if claims.NotBefore != 0 && now.Before(time.Unix(claims.NotBefore, 0).Add(-jwtLeeway)) {
+		return nil, errors.New("token not valid yet")
+	}
+	return &claims, nil
+}
+
+func jwtSignature(newHash func() hash.Hash, secret []byte, signed string) []byte {
+	mac := hmac.New(newHash, secret)
+	mac.Write([]byte(signed))
+	return mac.Sum(nil)
+}
+
+func decodeJWTPart(part string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// JWT returns an Authenticator accepting the tunnels presenting a token
+// signed with secret as their API key. The identity comes from the token
+// claims.
+func JWT(secret []byte) Authenticator {
+	return Func(func(ctx context.Context, req Request) (*Identity, error) {
+		claims, err := ParseJWT(secret, req.Handshake.APIKey, time.Now())
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUnauthorized, err)
+		}
+
+		return &Identity{
+			Subject:   claims.Subject,
+			TunnelIDs: claims.Tunnels,
+			ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+		}, nil
+	})
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseJWT(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	now := time.Unix(1_800_000_000, 0)
+
+	valid, err := SignJWT(secret, Claims{Subject: "alice", Tunnels: []string{"alice"}, ExpiresAt: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, _ := SignJWT(secret, Claims{Subject: "alice", ExpiresAt: now.Add(-time.Hour).Unix()})
+	notYet, _ := SignJWT(secret, Claims{Subject: "alice", NotBefore: now.Add(time.Hour).Unix(), ExpiresAt: now.Add(2 * time.Hour).Unix()})
+	noExpiry, _ := SignJWT(secret, Claims{Subject: "alice", IssuedAt: now.Unix()})
+	expiresBeforeIssued, _ := SignJWT(secret, Claims{Subject: "alice", IssuedAt: now.Add(2 * time.Hour).Unix(), ExpiresAt: now.Add(time.Hour).Unix()})
+	otherSecret, _ := SignJWT([]byte("another secret, also 32 bytes ok"), Claims{Subject: "alice", ExpiresAt: now.Add(time.Hour).Unix()})
+	parts := strings.Split(valid, ".")
+	unsigned := "eyJhbGciOiJub25lIn0." + parts[1] + "."
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{name: "valid", token: valid},
+		{name: "expired", token: expired, wantErr: "token expired"},
+		{name: "not valid yet", token: notYet, wantErr: "token not valid yet"},
+		{name: "no expiry", token: noExpiry, wantErr: "token has no expiry"},
+		{name: "expires before issued", token: expiresBeforeIssued, wantErr: "token expires before it was issued"},
+		{name: "other secret", token: otherSecret, wantErr: "invalid token signature"},
+		{name: "unsigned", token: unsigned, wantErr: "unsupported token algorithm"},
+		{name: "tampered", token: parts[0] + ".eyJzdWIiOiJib2IifQ." + parts[2], wantErr: "invalid token signature"},
+		{name: "malformed", token: "not-a-token", wantErr: "malformed token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := ParseJWT(secret, tt.token, now)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if claims.Subject != "alice" || len(claims.Tunnels) != 1 {
+				t.Errorf("unexpected claims %+v", claims)
+			}
+		})
+	}
+}
+
+func TestJWT(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	token, err := SignJWT(secret, Claims{Subject: "alice", Tunnels: []string{"alice-*"}, ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := JWT(secret).Authenticate(context.Background(), request(token))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if identity.Subject != "alice" || !identity.ExpiresAt.Equal(expiresAt) || identity.CanRegister("bob") {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+
+	if _, err := JWT(secret).Authenticate(context.Background(), request("secret")); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// keyFileEntry is a key in a key file.
+type keyFileEntry struct {
+	Name    string   `yaml:"name"`
+	Key     string   `yaml:"key"`
+	Tunnels []string `yaml:"tunnels,omitempty"`
+}
+
+// KeyFile authenticates tunnels with the API keys listed in a YAML file, each
+// with a name and optionally the tunnel IDs it may register:
+//
+//	keys:
+//	  - name: alice
+//	    key: 6f1c0b...
+//	    tunnels: [alice, "alice-*"]
+type KeyFile struct {
+	path string
+
+	mu   sync.RWMutex
+	keys []keyFileEntry
+}
+
+// LoadKeyFile reads the keys in the file at path.
+func LoadKeyFile(path string) (*KeyFile, error) {
+	f := &KeyFile{path: path}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload reads the file again, keeping the previous keys if it's invalid.
+func (f *KeyFile) Reload() error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	var file struct {
+		Keys []keyFileEntry `yaml:"keys"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return fmt.Errorf("invalid key file %s: %w", f.path, err)
+	}
+	names := make(map[string]bool)
+	for i, entry := range file.Keys {
+		if entry.Name == "" || entry.Key == "" {
+			return fmt.Errorf("invalid key file %s: key %d needs a name and a key", f.path, i+1)
+		}
+		if names[entry.Name] {
+			return fmt.Errorf("invalid key file %s: duplicate key name %q", f.path, entry.Name)
+		}
+		names[entry.Name] = true
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys = file.Keys
+	return nil
+}
+
+func (f *KeyFile) Authenticate(ctx context.Context, req Request) (*Identity, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	// Every key is compared so the time taken doesn't reveal a match.
+	var match *keyFileEntry
+	for i := range f.keys {
+		if equalKeys(req.Handshake.APIKey, f.keys[i].Key) && match == nil {
+			match = &f.keys[i]
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("%w: unknown API key", ErrUnauthorized)
+	}
+	return &Identity{Subject: match.Name, TunnelIDs: match.Tunnels}, nil
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// webhookRequest is the body posted to an authentication webhook.
+type webhookRequest struct {
+	TunnelID string `json:"tunnelID"`
+	APIKey   string `json:"apiKey"`
+	// Public is set for tunnels asking to be reachable without a bearer
+	// token.
+	Public     bool        `json:"public"`
+	RemoteAddr string      `json:"remoteAddr,omitempty"`
+	TLS        *webhookTLS `json:"tls,omitempty"`
+}
+
+// webhookTLS describes the TLS connection carrying a tunnel.
+type webhookTLS struct {
+	ServerName string `json:"serverName,omitempty"`
+	// ClientCertificates holds the subjects of the client certificate chain.
+	ClientCertificates []string `json:"clientCertificates,omitempty"`
+}
+
+// Webhook returns an Authenticator asking the service at url to approve every
+// tunnel. The request is posted as JSON, with the tunnel ID, API key, remote
+// address and TLS details. A 200 response approves it, with the Identity as
+// JSON body, a 401 or 403 denies it with the body as reason. client defaults
+// to http.DefaultClient, the handshake deadline bounds the call.
+func Webhook(url string, client *http.Client) Authenticator {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return Func(func(ctx context.Context, req Request) (*Identity, error) {
+		body := webhookRequest{
+			TunnelID: req.Handshake.TunnelID,
+			APIKey:   req.Handshake.APIKey,
+			Public:   req.Handshake.Bearer == nil,
+		}
+		if req.RemoteAddr != nil {
+			body.RemoteAddr = req.RemoteAddr.String()
+		}
+		if req.TLS != nil {
+			body.TLS = &webhookTLS{ServerName: req.TLS.ServerName}
+			for _, cert := range req.TLS.PeerCertificates {
+				body.TLS.ClientCertificates = append(body.TLS.ClientCertificates, cert.Subject.String())
+			}
+		}
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(httpReq)
+		if err != nil {
+			return nil, fmt.Errorf("auth webhook failed: %w", err)
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			var identity Identity
+			if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&identity); err != nil {
+				return nil, fmt.Errorf("invalid auth webhook response: %w", err)
+			}
+			return &identity, nil
+		case http.StatusUnauthorized, http.StatusForbidden:
+			reason, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			if msg := strings.TrimSpace(string(reason)); msg != "" {
+				return nil, fmt.Errorf("%w: %s", ErrUnauthorized, msg)
+			}
+			return nil, fmt.Errorf("%w: denied by auth webhook", ErrUnauthorized)
+		default:
+			return nil, fmt.Errorf("auth webhook returned %s", resp.Status)
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+	var received webhookRequest
+	stub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch received.APIKey {
+		case "alice-key":
+			json.NewEncoder(w).Encode(Identity{Subject: "alice", TunnelIDs: []string{"alice"}})
+		case "broken":
+			http.Error(w, "oops", http.StatusInternalServerError)
+		default:
+			http.Error(w, "unknown key", http.StatusForbidden)
+		}
+	}))
+	defer stub.Close()
+
+	authenticator := Webhook(stub.URL, nil)
+	req := request("alice-key")
+	req.RemoteAddr = &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4000}
+
+	identity, err := authenticator.Authenticate(context.Background(), req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if identity.Subject != "alice" || !identity.CanRegister("alice") || identity.CanRegister("bob") {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+	if received.TunnelID != "abcde" || received.RemoteAddr != "192.0.2.1:4000" || !received.Public {
+		t.Errorf("unexpected webhook request %+v", received)
+	}
+
+	_, err = authenticator.Authenticate(context.Background(), request("bob-key"))
+	if !errors.Is(err, ErrUnauthorized) || err.Error() != "unauthorized: unknown key" {
+		t.Errorf("expected the denial reason, got %v", err)
+	}
+
+	_, err = authenticator.Authenticate(context.Background(), request("broken"))
+	if err == nil || errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected a webhook failure, got %v", err)
+	}
+}
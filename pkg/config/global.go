@@ -4,24 +4,56 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
-// ConfigKey represents a configuration key.
-type ConfigKey string
+// GlobalConfig represents the user's global configuration. Its settings are
+// those of the default profile, named profiles override them.
+type GlobalConfig struct {
+	Settings `yaml:",inline"`
 
-const (
-	// KeyAPIKey is the configuration key for the API key.
-	KeyAPIKey ConfigKey = "api-key"
-	// KeyServer is the configuration key for the server address.
-	KeyServer ConfigKey = "server"
-)
+	// CurrentProfile is the profile used when none is selected, the default
+	// one when empty.
+	CurrentProfile string               `yaml:"current_profile,omitempty"`
+	Profiles       map[string]*Settings `yaml:"profiles,omitempty"`
+}
 
-// GlobalConfig represents the user's global configuration.
-type GlobalConfig struct {
+// Settings are the settings of a profile. They are described by the schema
+// returned by Schema, which the config commands work from.
+type Settings struct {
 	APIKey string `yaml:"api_key,omitempty"`
 	Server string `yaml:"server,omitempty"`
+
+	// Local is the default local service address.
+	Local string `yaml:"local,omitempty"`
+	// AuthMode is the default authentication of the tunnels, bearer or none.
+	AuthMode string `yaml:"auth_mode,omitempty"`
+
+	// Transport lists the transports tried to reach the server.
+	Transport string `yaml:"transport,omitempty"`
+	// Proxy is the URL of the proxy the server is reached through, "direct"
+	// ignores the proxy environment variables.
+	Proxy string `yaml:"proxy,omitempty"`
+	// ServerCAFile is trusted for the server certificate.
+	ServerCAFile string `yaml:"server_ca_file,omitempty"`
+
+	// TLS options of https local services.
+	LocalCAFile             string `yaml:"local_ca_file,omitempty"`
+	LocalCertFile           string `yaml:"local_cert_file,omitempty"`
+	LocalKeyFile            string `yaml:"local_key_file,omitempty"`
+	LocalInsecureSkipVerify *bool  `yaml:"local_insecure_skip_verify,omitempty"`
+
+	// Session settings asked from the server, zero values use the server
+	// defaults.
+	StreamWindowSize       uint32        `yaml:"stream_window_size,omitempty"`
+	KeepAliveInterval      time.Duration `yaml:"keepalive_interval,omitempty"`
+	ConnectionWriteTimeout time.Duration `yaml:"connection_write_timeout,omitempty"`
+	HeartbeatInterval      time.Duration `yaml:"heartbeat_interval,omitempty"`
+
+	// StatusAddr is the address of the local status endpoint.
+	StatusAddr string `yaml:"status_addr,omitempty"`
 }
 
 // getConfigDir returns the path to the config directory.
@@ -34,8 +66,8 @@ func getConfigDir() (string, error) {
 	return filepath.Join(home, ".config", "godig"), nil
 }
 
-// getConfigPath returns the path to the global config file.
-func getConfigPath() (string, error) {
+// Path returns the path to the global config file.
+func Path() (string, error) {
 	configDir, err := getConfigDir()
 	if err != nil {
 		return "", err
@@ -61,7 +93,7 @@ func ensureConfigDir() error {
 // LoadGlobalConfig loads the global configuration from ~/.config/godig/config.yaml.
 // Returns an empty config if the file doesn't exist.
 func LoadGlobalConfig() (*GlobalConfig, error) {
-	configPath, err := getConfigPath()
+	configPath, err := Path()
 	if err != nil {
 		return nil, err
 	}
@@ -88,7 +120,7 @@ func SaveGlobalConfig(config *GlobalConfig) error {
 		return err
 	}
 
-	configPath, err := getConfigPath()
+	configPath, err := Path()
 	if err != nil {
 		return err
 	}
@@ -105,38 +137,78 @@ func SaveGlobalConfig(config *GlobalConfig) error {
 	return nil
 }
 
-// SetConfigValue sets a specific configuration value and saves it.
-func SetConfigValue(key ConfigKey, value string) error {
-	config, err := LoadGlobalConfig()
+// ValidateFile reports the unknown keys and invalid settings of the global
+// configuration file at path.
+func ValidateFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var config GlobalConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return fmt.Errorf("failed to parse config file: %w", err)
+	}
+	return config.Validate()
+}
+
+// SetConfigValue sets a specific configuration value of profile and saves it.
+// An empty profile selects the current one.
+func SetConfigValue(profile string, key ConfigKey, value string) error {
+	setting, err := LookupSetting(key)
+	if err != nil {
+		return err
+	}
+	globalConfig, err := LoadGlobalConfig()
+	if err != nil {
+		return err
+	}
+	settings, err := globalConfig.ProfileSettings(profile)
 	if err != nil {
 		return err
 	}
 
-	switch key {
-	case KeyAPIKey:
-		config.APIKey = value
-	case KeyServer:
-		config.Server = value
-	default:
-		return fmt.Errorf("unknown config key: %s (valid keys: %s, %s)", key, KeyAPIKey, KeyServer)
+	if err := setting.Set(settings, value); err != nil {
+		return err
+	}
+	return SaveGlobalConfig(globalConfig)
+}
+
+// UnsetConfigValue removes a specific configuration value of profile and
+// saves it. An empty profile selects the current one.
+func UnsetConfigValue(profile string, key ConfigKey) error {
+	setting, err := LookupSetting(key)
+	if err != nil {
+		return err
+	}
+	globalConfig, err := LoadGlobalConfig()
+	if err != nil {
+		return err
+	}
+	settings, err := globalConfig.ProfileSettings(profile)
+	if err != nil {
+		return err
 	}
 
-	return SaveGlobalConfig(config)
+	setting.Unset(settings)
+	return SaveGlobalConfig(globalConfig)
 }
 
-// GetConfigValue retrieves a specific configuration value.
-func GetConfigValue(key ConfigKey) (string, error) {
-	config, err := LoadGlobalConfig()
+// GetConfigValue retrieves a specific configuration value of profile, as set
+// in that profile. An empty profile selects the current one.
+func GetConfigValue(profile string, key ConfigKey) (string, error) {
+	setting, err := LookupSetting(key)
 	if err != nil {
 		return "", err
 	}
-
-	switch key {
-	case KeyAPIKey:
-		return config.APIKey, nil
-	case KeyServer:
-		return config.Server, nil
-	default:
-		return "", fmt.Errorf("unknown config key: %s", key)
+	globalConfig, err := LoadGlobalConfig()
+	if err != nil {
+		return "", err
 	}
+	settings, err := globalConfig.ProfileSettings(profile)
+	if err != nil {
+		return "", err
+	}
+
+	return setting.Get(settings), nil
 }
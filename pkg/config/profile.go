@@ -0,0 +1,162 @@
+package config
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// DefaultProfile names the profile made of the top level settings of the
+// global configuration.
+const DefaultProfile = "default"
+
+// validProfileName matches the names profiles can be created with.
+var validProfileName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// ProfileName returns the name of the profile selected by name, the current
+// profile when empty.
+func (c *GlobalConfig) ProfileName(name string) string {
+	return cmp.Or(name, c.CurrentProfile, DefaultProfile)
+}
+
+// Profile returns the settings of the profile selected by name, the current
+// profile when empty. Settings missing from a named profile are taken from the
+// default one.
+func (c *GlobalConfig) Profile(name string) (Settings, error) {
+	profile, err := c.ProfileSettings(name)
+	if err != nil {
+		return Settings{}, err
+	}
+
+	merged := *profile
+	for _, setting := range schema {
+		if setting.Get(&merged) != "" {
+			continue
+		}
+		if value := setting.Get(&c.Settings); value != "" {
+			if err := setting.Set(&merged, value); err != nil {
+				return Settings{}, fmt.Errorf("profile %s: %w", DefaultProfile, err)
+			}
+		}
+	}
+	return merged, nil
+}
+
+// Validate reports the invalid settings of every profile, and a current
+// profile that doesn't exist.
+func (c *GlobalConfig) Validate() error {
+	var errs []error
+	for _, name := range c.ProfileNames() {
+		profile, err := c.ProfileSettings(name)
+		if err != nil {
+			return err
+		}
+		for _, setting := range schema {
+			value := setting.Get(profile)
+			if value == "" {
+				continue
+			}
+			// Values are checked by setting them again.
+			var scratch Settings
+			if err := setting.Set(&scratch, value); err != nil {
+				errs = append(errs, fmt.Errorf("profile %s: %w", name, err))
+			}
+		}
+	}
+	if _, err := c.ProfileSettings(""); err != nil {
+		errs = append(errs, fmt.Errorf("current_profile: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// ProfileSettings returns the settings set in the profile selected by name,
+// the current profile when empty, without the defaults.
+func (c *GlobalConfig) ProfileSettings(name string) (*Settings, error) {
+	name = c.ProfileName(name)
+	if name == DefaultProfile {
+		return &c.Settings, nil
+	}
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q", name)
+	}
+	if profile == nil {
+		// An empty profile in the file.
+		profile = &Settings{}
+		c.Profiles[name] = profile
+	}
+	return profile, nil
+}
+
+// ProfileNames returns the names of the profiles, the default one first.
+func (c *GlobalConfig) ProfileNames() []string {
+	names := []string{DefaultProfile}
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names[1:])
+	return names
+}
+
+// CreateProfile adds an empty profile and saves the configuration.
+func CreateProfile(name string) error {
+	if !validProfileName.MatchString(name) || name == DefaultProfile {
+		return fmt.Errorf("invalid profile name %q: use letters, digits, - and _, other than %s", name, DefaultProfile)
+	}
+
+	config, err := LoadGlobalConfig()
+	if err != nil {
+		return err
+	}
+	if _, ok := config.Profiles[name]; ok {
+		return fmt.Errorf("profile %q already exists", name)
+	}
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]*Settings)
+	}
+	config.Profiles[name] = &Settings{}
+	return SaveGlobalConfig(config)
+}
+
+// DeleteProfile removes a profile and saves the configuration. The default
+// profile becomes the current one if it was.
+func DeleteProfile(name string) error {
+	if name == DefaultProfile {
+		return fmt.Errorf("the %s profile can't be deleted", DefaultProfile)
+	}
+
+	config, err := LoadGlobalConfig()
+	if err != nil {
+		return err
+	}
+	if _, ok := config.Profiles[name]; !ok {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+	delete(config.Profiles, name)
+	if config.CurrentProfile == name {
+		config.CurrentProfile = ""
+	}
+	return SaveGlobalConfig(config)
+}
+
+// UseProfile makes name the current profile and saves the configuration.
+func UseProfile(name string) error {
+	if name == "" {
+		return fmt.Errorf("a profile name is required")
+	}
+
+	config, err := LoadGlobalConfig()
+	if err != nil {
+		return err
+	}
+	if _, err := config.ProfileSettings(name); err != nil {
+		return err
+	}
+	config.CurrentProfile = name
+	if name == DefaultProfile {
+		config.CurrentProfile = ""
+	}
+	return SaveGlobalConfig(config)
+}
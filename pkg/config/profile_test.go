@@ -0,0 +1,71 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProfiles(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if err := SetConfigValue("", KeyServer, "godig.xyz:8080"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetConfigValue("", KeyHeartbeatInterval, "10s"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetConfigValue("internal", KeyServer, "tunnels.internal:8080"); err == nil {
+		t.Error("expected setting a value of an unknown profile to fail")
+	}
+	if err := CreateProfile("internal"); err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateProfile("internal"); err == nil {
+		t.Error("expected creating an existing profile to fail")
+	}
+	if err := CreateProfile(DefaultProfile); err == nil {
+		t.Error("expected creating the default profile to fail")
+	}
+	if err := SetConfigValue("internal", KeyServer, "tunnels.internal:8080"); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadGlobalConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile, err := config.Profile("internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Server != "tunnels.internal:8080" || profile.HeartbeatInterval != 10*time.Second {
+		t.Errorf("expected the profile server over the default settings, got %+v", profile)
+	}
+	if value, _ := GetConfigValue("internal", KeyHeartbeatInterval); value != "" {
+		t.Errorf("expected get to only show the profile's own settings, got %q", value)
+	}
+	if _, err := config.Profile("missing"); err == nil {
+		t.Error("expected an unknown profile to fail")
+	}
+
+	if err := UseProfile("internal"); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := GetConfigValue("", KeyServer); value != "tunnels.internal:8080" {
+		t.Errorf("expected the current profile to be internal, got server %q", value)
+	}
+
+	if err := DeleteProfile("internal"); err != nil {
+		t.Fatal(err)
+	}
+	config, err = LoadGlobalConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := config.ProfileNames(); len(names) != 1 || config.ProfileName("") != DefaultProfile {
+		t.Errorf("expected only the default profile to be left and current, got %v", names)
+	}
+	if err := DeleteProfile(DefaultProfile); err == nil {
+		t.Error("expected deleting the default profile to fail")
+	}
+}
@@ -0,0 +1,138 @@
+package deadline
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Conn wraps a net.Conn and pushes its deadline forward on every read and
+// write, so the connection is only closed after a period of inactivity in both
+// directions. An optional maximum duration caps the deadline regardless of
+// activity.
+//
+// Deadlines set through SetDeadline, SetReadDeadline and SetWriteDeadline are
+// still honored, the earliest of them and the idle deadline wins.
+type Conn struct {
+	net.Conn
+
+	idleTimeout time.Duration
+	// hardDeadline is zero when the connection has no maximum duration.
+	hardDeadline time.Time
+
+	mu       sync.Mutex
+	lastSeen time.Time
+	aborted  bool
+	// idleDeadline is the deadline derived from the last activity.
+	idleDeadline time.Time
+	// readDeadline and writeDeadline are the deadlines set by users of the
+	// connection, zero means none.
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// NewConn returns conn wrapped with an idle timeout and a maximum duration.
+// A zero idleTimeout or maxDuration disables the respective limit.
+func NewConn(conn net.Conn, idleTimeout, maxDuration time.Duration) *Conn {
+	c := &Conn{
+		Conn:        conn,
+		idleTimeout: idleTimeout,
+	}
+	if maxDuration > 0 {
+		c.hardDeadline = time.Now().Add(maxDuration)
+	}
+	c.Touch()
+	return c
+}
+
+// Touch records activity on the connection and extends its deadline.
+func (c *Conn) Touch() {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.aborted {
+		return
+	}
+	// Renewing the deadline on every small read or write is wasteful, skip it
+	// when the last renewal is recent enough.
+	if !c.lastSeen.IsZero() && now.Sub(c.lastSeen) < c.idleTimeout/16 {
+		return
+	}
+	c.lastSeen = now
+
+	var d time.Time
+	if c.idleTimeout > 0 {
+		d = now.Add(c.idleTimeout)
+	}
+	c.idleDeadline = earliest(d, c.hardDeadline)
+	c.apply()
+}
+
+// Abort expires the deadline right away, failing pending and future reads and
+// writes. Unlike Close, it also unblocks readers of half-closed streams.
+func (c *Conn) Abort() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.aborted = true
+	c.idleDeadline = time.Now()
+	c.apply()
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.Touch()
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.Touch()
+	}
+	return n, err
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.Touch()
+	return c.Conn.Write(b)
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	c.writeDeadline = t
+	return c.apply()
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	return c.apply()
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writeDeadline = t
+	return c.apply()
+}
+
+// apply sets the effective deadlines on the underlying connection. It must be
+// called with mu held.
+func (c *Conn) apply() error {
+	if err := c.Conn.SetReadDeadline(earliest(c.idleDeadline, c.readDeadline)); err != nil {
+		return err
+	}
+	return c.Conn.SetWriteDeadline(earliest(c.idleDeadline, c.writeDeadline))
+}
+
+// earliest returns the earliest of two deadlines, where zero means none.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
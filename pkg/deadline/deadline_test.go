@@ -0,0 +1,103 @@
+package deadline
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConn_IdleTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := NewConn(server, 50*time.Millisecond, 0)
+
+	buf := make([]byte, 1)
+	_, err := conn.Read(buf)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestConn_ActivityExtendsDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := NewConn(server, 100*time.Millisecond, 0)
+
+	go func() {
+		for i := 0; i < 5; i++ {
+			time.Sleep(40 * time.Millisecond)
+			client.Write([]byte{'x'})
+		}
+	}()
+
+	buf := make([]byte, 1)
+	for i := 0; i < 5; i++ {
+		if _, err := conn.Read(buf); err != nil {
+			t.Fatalf("read %d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestConn_MaxDuration(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := NewConn(server, time.Second, 100*time.Millisecond)
+
+	go func() {
+		for {
+			time.Sleep(20 * time.Millisecond)
+			if _, err := client.Write([]byte{'x'}); err != nil {
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	buf := make([]byte, 1)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatalf("expected deadline exceeded, got %v", err)
+			}
+			break
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the max duration to cut the connection, took %v", elapsed)
+	}
+}
+
+func TestConn_ExplicitDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := NewConn(server, time.Second, 0)
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+
+	start := time.Now()
+	buf := make([]byte, 1)
+	_, err := conn.Read(buf)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the explicit deadline to win, took %v", elapsed)
+	}
+
+	// Clearing the explicit deadline falls back to the idle timeout.
+	conn.SetReadDeadline(time.Time{})
+	go client.Write([]byte{'x'})
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatalf("unexpected error after clearing the deadline: %v", err)
+	}
+}
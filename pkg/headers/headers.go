@@ -1,10 +1,20 @@
 package headers
 
 import (
+	"net"
 	"net/http"
 	"strings"
 )
 
+// ClientAddrHeader and PublicAddrHeader carry the source and destination
+// addresses of the public connection from the server to the tunnel client,
+// which removes them before forwarding the request. They are used to send
+// the PROXY protocol header.
+const (
+	ClientAddrHeader = "Godig-Client-Addr"
+	PublicAddrHeader = "Godig-Public-Addr"
+)
+
 var hopByHopHeaders = map[string]bool{
 	"Connection":          true,
 	"Keep-Alive":          true,
@@ -17,16 +27,48 @@ var hopByHopHeaders = map[string]bool{
 }
 
 func RemoveHopByHopHeaders(header http.Header) {
+	// Remove headers listed in the Connection header first, it is removed
+	// below.
+	for _, value := range header.Values("Connection") {
+		for _, h := range strings.Split(value, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				header.Del(h)
+			}
+		}
+	}
+
 	for name := range hopByHopHeaders {
 		header.Del(name)
 	}
+}
 
-	// Also remove headers listed in Connection header
-	if connectionHeader := header.Get("Connection"); connectionHeader != "" {
-		for _, h := range strings.Split(connectionHeader, ",") {
-			header.Del(strings.TrimSpace(h))
-		}
+// PrepareRequest readies req to be forwarded to a tunnel. Hop-by-hop headers
+// are removed, except for the ones needed to switch protocols or to receive
+// trailers, and the proxy headers for clientIP are added.
+func PrepareRequest(req *http.Request, clientIP string) {
+	upgrade := UpgradeType(req.Header)
+	trailers := hasToken(req.Header, "Te", "trailers")
+
+	RemoveHopByHopHeaders(req.Header)
+
+	if upgrade != "" {
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", upgrade)
+	}
+	if trailers {
+		req.Header.Set("Te", "trailers")
+	}
+
+	AddProxyHeaders(req, clientIP)
+}
+
+// UpgradeType returns the protocol asked for in the Upgrade header, or an
+// empty string when the headers don't request a protocol switch.
+func UpgradeType(header http.Header) string {
+	if !hasToken(header, "Connection", "upgrade") {
+		return ""
 	}
+	return header.Get("Upgrade")
 }
 
 func AddProxyHeaders(req *http.Request, clientIP string) {
@@ -38,14 +80,61 @@ func AddProxyHeaders(req *http.Request, clientIP string) {
 	}
 
 	// Add X-Forwarded-Proto
+	proto := "http"
 	if req.TLS != nil {
-		req.Header.Set("X-Forwarded-Proto", "https")
-	} else {
-		req.Header.Set("X-Forwarded-Proto", "http")
+		proto = "https"
 	}
+	req.Header.Set("X-Forwarded-Proto", proto)
 
 	// Add X-Forwarded-Host
 	if req.Header.Get("X-Forwarded-Host") == "" {
 		req.Header.Set("X-Forwarded-Host", req.Host)
 	}
+
+	// The tunnel server is the edge, the peer address is the real client.
+	req.Header.Set("X-Real-IP", clientIP)
+
+	// Add the RFC 7239 Forwarded element for this hop.
+	element := "for=" + forwardedNode(clientIP) + ";host=" + forwardedValue(req.Host) + ";proto=" + proto
+	if prior := strings.Join(req.Header.Values("Forwarded"), ", "); prior != "" {
+		req.Header.Set("Forwarded", prior+", "+element)
+	} else {
+		req.Header.Set("Forwarded", element)
+	}
+}
+
+// forwardedNode formats ip as a Forwarded node, IPv6 addresses are bracketed
+// and quoted.
+func forwardedNode(ip string) string {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		return `"[` + ip + `]"`
+	}
+	return forwardedValue(ip)
+}
+
+// forwardedValue quotes value unless it is a valid token.
+func forwardedValue(value string) string {
+	if value != "" && !strings.ContainsFunc(value, func(r rune) bool { return !isTokenChar(r) }) {
+		return value
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+}
+
+func isTokenChar(r rune) bool {
+	if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+}
+
+// hasToken reports whether the comma separated header name contains token.
+func hasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
 }
@@ -0,0 +1,113 @@
+package headers
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoveHopByHopHeaders(t *testing.T) {
+	header := http.Header{
+		"Connection":        {"keep-alive, X-Internal"},
+		"Keep-Alive":        {"timeout=5"},
+		"X-Internal":        {"secret"},
+		"Transfer-Encoding": {"chunked"},
+		"Content-Type":      {"text/plain"},
+	}
+
+	RemoveHopByHopHeaders(header)
+
+	if len(header) != 1 || header.Get("Content-Type") != "text/plain" {
+		t.Errorf("expected only Content-Type to remain, got %v", header)
+	}
+}
+
+func TestPrepareRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteIP   string
+		tls        bool
+		header     http.Header
+		expected   map[string]string
+		unexpected []string
+	}{
+		{
+			name:     "proxy headers",
+			remoteIP: "203.0.113.7",
+			tls:      true,
+			expected: map[string]string{
+				"X-Forwarded-For":   "203.0.113.7",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "abcde.godig.xyz",
+				"X-Real-IP":         "203.0.113.7",
+				"Forwarded":         "for=203.0.113.7;host=abcde.godig.xyz;proto=https",
+			},
+		},
+		{
+			name:     "appends to prior hops",
+			remoteIP: "2001:db8::1",
+			header: http.Header{
+				"X-Forwarded-For": {"198.51.100.1"},
+				"Forwarded":       {"for=198.51.100.1"},
+				"X-Real-Ip":       {"198.51.100.1"},
+			},
+			expected: map[string]string{
+				"X-Forwarded-For":   "198.51.100.1, 2001:db8::1",
+				"X-Forwarded-Proto": "http",
+				"X-Real-IP":         "2001:db8::1",
+				"Forwarded":         `for=198.51.100.1, for="[2001:db8::1]";host=abcde.godig.xyz;proto=http`,
+			},
+		},
+		{
+			name:     "hop-by-hop headers are removed",
+			remoteIP: "203.0.113.7",
+			header: http.Header{
+				"Connection":          {"close, X-Internal"},
+				"X-Internal":          {"secret"},
+				"Proxy-Authorization": {"Basic Zm9vOmJhcg=="},
+				"Te":                  {"gzip"},
+			},
+			unexpected: []string{"Connection", "X-Internal", "Proxy-Authorization", "Te", "Upgrade"},
+		},
+		{
+			name:     "upgrade and trailers are kept",
+			remoteIP: "203.0.113.7",
+			header: http.Header{
+				"Connection": {"keep-alive, Upgrade"},
+				"Upgrade":    {"websocket"},
+				"Te":         {"trailers, deflate"},
+			},
+			expected: map[string]string{
+				"Connection": "Upgrade",
+				"Upgrade":    "websocket",
+				"Te":         "trailers",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "http://abcde.godig.xyz/", nil)
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+
+			PrepareRequest(req, tt.remoteIP)
+
+			for key, value := range tt.expected {
+				if got := req.Header.Get(key); got != value {
+					t.Errorf("expected %s %q, got %q", key, value, got)
+				}
+			}
+			for _, key := range tt.unexpected {
+				if got := req.Header.Get(key); got != "" {
+					t.Errorf("expected %s to be removed, got %q", key, got)
+				}
+			}
+		})
+	}
+}
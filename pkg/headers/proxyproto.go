@@ -0,0 +1,115 @@
+package headers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// PROXY protocol versions accepted by WriteProxyHeader.
+const (
+	ProxyProtocolV1 = "v1"
+	ProxyProtocolV2 = "v2"
+)
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ValidateProxyProtocol returns an error for unknown PROXY protocol versions.
+// An empty version disables the protocol.
+func ValidateProxyProtocol(version string) error {
+	switch version {
+	case "", ProxyProtocolV1, ProxyProtocolV2:
+		return nil
+	}
+	return fmt.Errorf("unsupported PROXY protocol version %q, use %s or %s", version, ProxyProtocolV1, ProxyProtocolV2)
+}
+
+// WriteProxyHeader writes a PROXY protocol header announcing a connection from
+// src to dst. When either address is not a TCP address the connection is
+// announced as UNKNOWN in v1 and LOCAL in v2, so the receiver uses the real
+// connection addresses.
+func WriteProxyHeader(w io.Writer, version string, src, dst net.Addr) error {
+	var header []byte
+	switch version {
+	case ProxyProtocolV1:
+		header = proxyHeaderV1(src, dst)
+	case ProxyProtocolV2:
+		header = proxyHeaderV2(src, dst)
+	default:
+		return ValidateProxyProtocol(version)
+	}
+
+	_, err := w.Write(header)
+	return err
+}
+
+func proxyHeaderV1(src, dst net.Addr) []byte {
+	srcAddr, dstAddr, ok := proxyAddrs(src, dst)
+	if !ok {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+
+	family := "TCP4"
+	if srcAddr.Addr().Is6() {
+		family = "TCP6"
+	}
+	return []byte("PROXY " + family + " " +
+		srcAddr.Addr().String() + " " + dstAddr.Addr().String() + " " +
+		strconv.Itoa(int(srcAddr.Port())) + " " + strconv.Itoa(int(dstAddr.Port())) + "\r\n")
+}
+
+func proxyHeaderV2(src, dst net.Addr) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+
+	srcAddr, dstAddr, ok := proxyAddrs(src, dst)
+	if !ok {
+		// LOCAL command, unspecified family and no addresses.
+		return append(header, 0x20, 0x00, 0x00, 0x00)
+	}
+
+	family := byte(0x11) // TCP over IPv4.
+	if srcAddr.Addr().Is6() {
+		family = 0x21 // TCP over IPv6.
+	}
+	addrs := srcAddr.Addr().AsSlice()
+	addrs = append(addrs, dstAddr.Addr().AsSlice()...)
+	addrs = binary.BigEndian.AppendUint16(addrs, srcAddr.Port())
+	addrs = binary.BigEndian.AppendUint16(addrs, dstAddr.Port())
+
+	// PROXY command, version 2.
+	header = append(header, 0x21, family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addrs)))
+	return append(header, addrs...)
+}
+
+// proxyAddrs returns src and dst as TCP addresses of the same family. IPv4
+// addresses are mapped to IPv6 when the other address is IPv6.
+func proxyAddrs(src, dst net.Addr) (netip.AddrPort, netip.AddrPort, bool) {
+	srcAddr, srcOK := tcpAddrPort(src)
+	dstAddr, dstOK := tcpAddrPort(dst)
+	if !srcOK || !dstOK {
+		return netip.AddrPort{}, netip.AddrPort{}, false
+	}
+
+	if srcAddr.Addr().Is4() != dstAddr.Addr().Is4() {
+		srcAddr = netip.AddrPortFrom(netip.AddrFrom16(srcAddr.Addr().As16()), srcAddr.Port())
+		dstAddr = netip.AddrPortFrom(netip.AddrFrom16(dstAddr.Addr().As16()), dstAddr.Port())
+	}
+	return srcAddr, dstAddr, true
+}
+
+func tcpAddrPort(addr net.Addr) (netip.AddrPort, bool) {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || tcpAddr == nil {
+		return netip.AddrPort{}, false
+	}
+	addrPort := tcpAddr.AddrPort()
+	if !addrPort.Addr().IsValid() {
+		return netip.AddrPort{}, false
+	}
+	// Keep IPv4 addresses in their 4 byte form.
+	return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port()), true
+}
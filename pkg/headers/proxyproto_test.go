@@ -0,0 +1,102 @@
+package headers
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestWriteProxyHeader(t *testing.T) {
+	src4 := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51000}
+	dst4 := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 3000}
+	src6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 51000}
+	signature := "\r\n\r\n\x00\r\nQUIT\n"
+
+	tests := []struct {
+		name     string
+		version  string
+		src      net.Addr
+		dst      net.Addr
+		expected string
+	}{
+		{
+			name:     "v1 tcp4",
+			version:  ProxyProtocolV1,
+			src:      src4,
+			dst:      dst4,
+			expected: "PROXY TCP4 203.0.113.7 127.0.0.1 51000 3000\r\n",
+		},
+		{
+			name:     "v1 mixed families",
+			version:  ProxyProtocolV1,
+			src:      src6,
+			dst:      dst4,
+			expected: "PROXY TCP6 2001:db8::1 ::ffff:127.0.0.1 51000 3000\r\n",
+		},
+		{
+			name:     "v1 unknown",
+			version:  ProxyProtocolV1,
+			src:      nil,
+			dst:      &net.UnixAddr{Name: "/run/app.sock", Net: "unix"},
+			expected: "PROXY UNKNOWN\r\n",
+		},
+		{
+			name:    "v2 tcp4",
+			version: ProxyProtocolV2,
+			src:     src4,
+			dst:     dst4,
+			expected: signature + "\x21\x11\x00\x0c" +
+				"\xcb\x00\x71\x07" + "\x7f\x00\x00\x01" + "\xc7\x38" + "\x0b\xb8",
+		},
+		{
+			name:     "v2 local",
+			version:  ProxyProtocolV2,
+			src:      nil,
+			dst:      dst4,
+			expected: signature + "\x20\x00\x00\x00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteProxyHeader(&buf, tt.version, tt.src, tt.dst); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, buf.String())
+			}
+		})
+	}
+}
+
+func TestWriteProxyHeader_V2TCP6(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 51000}
+	dst := &net.TCPAddr{IP: net.ParseIP("::1"), Port: 3000}
+
+	var buf bytes.Buffer
+	if err := WriteProxyHeader(&buf, ProxyProtocolV2, src, dst); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	header := buf.Bytes()
+	if len(header) != 16+36 {
+		t.Fatalf("expected 52 bytes, got %d", len(header))
+	}
+	if header[12] != 0x21 || header[13] != 0x21 {
+		t.Errorf("expected PROXY command over TCP6, got %#x %#x", header[12], header[13])
+	}
+	if !net.IP(header[16:32]).Equal(src.IP) || !net.IP(header[32:48]).Equal(dst.IP) {
+		t.Errorf("unexpected addresses %v %v", net.IP(header[16:32]), net.IP(header[32:48]))
+	}
+}
+
+func TestWriteProxyHeader_UnsupportedVersion(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteProxyHeader(&buf, "v3", nil, nil); err == nil {
+		t.Error("expected error but got none")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing written, got %q", buf.String())
+	}
+}
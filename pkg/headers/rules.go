@@ -0,0 +1,107 @@
+package headers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/AYM1607/godig/types"
+)
+
+// ParseHeader parses a header given as "Name: value".
+func ParseHeader(s string) (string, string, error) {
+	name, value, ok := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("invalid header %q, expected \"Name: value\"", s)
+	}
+	return http.CanonicalHeaderKey(name), strings.TrimSpace(value), nil
+}
+
+// ApplyRules applies rules to header: removals first, then sets and finally
+// additions.
+func ApplyRules(header http.Header, rules types.HeaderRules) {
+	for _, name := range rules.Remove {
+		header.Del(name)
+	}
+	for name, value := range rules.Set {
+		header.Set(name, value)
+	}
+	for name, values := range rules.Add {
+		for _, value := range values {
+			header.Add(name, value)
+		}
+	}
+}
+
+// MergeRules returns the rules in base extended with the ones in override,
+// which win for headers present in both.
+func MergeRules(base, override types.HeaderRules) types.HeaderRules {
+	merged := types.HeaderRules{
+		Set:    mergeMaps(base.Set, override.Set),
+		Add:    mergeMaps(base.Add, override.Add),
+		Remove: append(append([]string{}, base.Remove...), override.Remove...),
+	}
+	if len(merged.Remove) == 0 {
+		merged.Remove = nil
+	}
+	return merged
+}
+
+func mergeMaps[V any](base, override map[string]V) map[string]V {
+	if len(base) == 0 && len(override) == 0 {
+		return nil
+	}
+	merged := make(map[string]V, len(base)+len(override))
+	for name, value := range base {
+		merged[http.CanonicalHeaderKey(name)] = value
+	}
+	for name, value := range override {
+		merged[http.CanonicalHeaderKey(name)] = value
+	}
+	return merged
+}
+
+// RulesHandler returns a handler that applies the request rules to requests
+// before calling h, and the response rules to the responses written by h. A
+// non-empty host replaces the request host.
+func RulesHandler(h http.Handler, host string, request, response types.HeaderRules) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if host != "" {
+			r.Host = host
+		}
+		ApplyRules(r.Header, request)
+
+		h.ServeHTTP(&rulesResponseWriter{ResponseWriter: w, rules: response}, r)
+	})
+}
+
+// rulesResponseWriter applies rules to the response headers right before they
+// are written.
+type rulesResponseWriter struct {
+	http.ResponseWriter
+	rules       types.HeaderRules
+	wroteHeader bool
+}
+
+func (w *rulesResponseWriter) WriteHeader(code int) {
+	// Informational responses can be written many times before the final one.
+	if !w.wroteHeader && code >= http.StatusOK {
+		w.wroteHeader = true
+		ApplyRules(w.Header(), w.rules)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *rulesResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to reach the flushing and hijacking
+// support of the wrapped writer.
+func (w *rulesResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
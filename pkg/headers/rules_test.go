@@ -0,0 +1,132 @@
+package headers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/AYM1607/godig/types"
+)
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedName  string
+		expectedValue string
+		expectError   bool
+	}{
+		{input: "X-Env: dev", expectedName: "X-Env", expectedValue: "dev"},
+		{input: "cache-control:no-store", expectedName: "Cache-Control", expectedValue: "no-store"},
+		{input: "X-Empty:", expectedName: "X-Empty", expectedValue: ""},
+		{input: "X-Url: http://localhost:3000", expectedName: "X-Url", expectedValue: "http://localhost:3000"},
+		{input: "no-colon", expectError: true},
+		{input: ": value", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			name, value, err := ParseHeader(tt.input)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if name != tt.expectedName || value != tt.expectedValue {
+				t.Errorf("expected %q: %q, got %q: %q", tt.expectedName, tt.expectedValue, name, value)
+			}
+		})
+	}
+}
+
+func TestApplyRules(t *testing.T) {
+	header := http.Header{
+		"Cookie": {"session=1"},
+		"Via":    {"1.1 proxy"},
+		"X-Env":  {"prod"},
+	}
+
+	ApplyRules(header, types.HeaderRules{
+		Remove: []string{"cookie"},
+		Set:    map[string]string{"X-Env": "dev"},
+		Add: map[string]types.HeaderValues{
+			"Via":       {"1.1 godig"},
+			"X-Feature": {"search", "beta"},
+		},
+	})
+
+	expected := http.Header{
+		"Via":       {"1.1 proxy", "1.1 godig"},
+		"X-Env":     {"dev"},
+		"X-Feature": {"search", "beta"},
+	}
+	if !reflect.DeepEqual(header, expected) {
+		t.Errorf("expected %v, got %v", expected, header)
+	}
+}
+
+func TestMergeRules(t *testing.T) {
+	base := types.HeaderRules{
+		Set:    map[string]string{"x-env": "prod", "X-Team": "web"},
+		Remove: []string{"Cookie"},
+	}
+	override := types.HeaderRules{
+		Set:    map[string]string{"X-Env": "dev"},
+		Remove: []string{"Authorization"},
+	}
+
+	merged := MergeRules(base, override)
+
+	expectedSet := map[string]string{"X-Env": "dev", "X-Team": "web"}
+	if !reflect.DeepEqual(merged.Set, expectedSet) {
+		t.Errorf("expected set rules %v, got %v", expectedSet, merged.Set)
+	}
+	if merged.Add != nil {
+		t.Errorf("expected no add rules, got %v", merged.Add)
+	}
+	expectedRemove := []string{"Cookie", "Authorization"}
+	if !reflect.DeepEqual(merged.Remove, expectedRemove) {
+		t.Errorf("expected remove rules %v, got %v", expectedRemove, merged.Remove)
+	}
+}
+
+func TestRulesHandler(t *testing.T) {
+	var gotHost, gotEnv string
+	handler := RulesHandler(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotHost = r.Host
+			gotEnv = r.Header.Get("X-Env")
+			w.Header().Set("Content-Security-Policy", "default-src 'self'")
+			w.Write([]byte("ok"))
+		}),
+		"localhost:3000",
+		types.HeaderRules{Set: map[string]string{"X-Env": "dev"}},
+		types.HeaderRules{
+			Remove: []string{"Content-Security-Policy"},
+			Set:    map[string]string{"Access-Control-Allow-Origin": "*"},
+		},
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "http://abcde.godig.xyz/", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if gotHost != "localhost:3000" {
+		t.Errorf("expected host localhost:3000, got %q", gotHost)
+	}
+	if gotEnv != "dev" {
+		t.Errorf("expected X-Env dev, got %q", gotEnv)
+	}
+	if csp := rec.Header().Get("Content-Security-Policy"); csp != "" {
+		t.Errorf("expected Content-Security-Policy to be removed, got %q", csp)
+	}
+	if cors := rec.Header().Get("Access-Control-Allow-Origin"); cors != "*" {
+		t.Errorf("expected Access-Control-Allow-Origin *, got %q", cors)
+	}
+}
@@ -0,0 +1,68 @@
+package pipe
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"time"
+)
+
+// halfCloseTimeout is how long the other direction of a joined connection is
+// given to finish after one direction is done.
+const halfCloseTimeout = 30 * time.Second
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// Join copies data between a and b in both directions, as needed for
+// upgraded connections. When one direction is done the write side of its
+// destination is closed, and the other direction gets some time to finish
+// before Join returns. The connections are not closed by Join.
+func Join(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go copyAndCloseWrite(a, b, done)
+	go copyAndCloseWrite(b, a, done)
+
+	<-done
+	select {
+	case <-done:
+	case <-time.After(halfCloseTimeout):
+	}
+}
+
+func copyAndCloseWrite(dst, src net.Conn, done chan<- struct{}) {
+	io.Copy(dst, src)
+	if cw, ok := dst.(closeWriter); ok {
+		cw.CloseWrite()
+	} else {
+		// Multiplexed streams only close their write side on Close.
+		dst.Close()
+	}
+	done <- struct{}{}
+}
+
+// BufferedConn is a net.Conn whose reads are served from a buffered reader
+// first, for connections that have been partially read through bufio.
+type BufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// NewBufferedConn returns conn reading through r, which must wrap conn.
+func NewBufferedConn(conn net.Conn, r *bufio.Reader) *BufferedConn {
+	return &BufferedConn{Conn: conn, r: r}
+}
+
+func (c *BufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// CloseWrite closes the write side of the underlying connection if supported,
+// or the whole connection otherwise.
+func (c *BufferedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(closeWriter); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
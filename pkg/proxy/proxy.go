@@ -0,0 +1,192 @@
+// Package proxy connects to remote addresses through HTTP CONNECT and SOCKS5
+// proxies.
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+
+	"golang.org/x/net/http/httpproxy"
+	xproxy "golang.org/x/net/proxy"
+
+	"github.com/AYM1607/godig/pkg/pipe"
+)
+
+// Direct disables proxies, including the ones set in the environment, when
+// given as the proxy URL.
+const Direct = "direct"
+
+// Func returns the proxy used to reach addr, nil for a direct connection.
+type Func func(addr string) (*url.URL, error)
+
+// Parse validates a proxy URL. The scheme is one of http, https, socks5 or
+// socks5h, credentials go in the user info.
+func Parse(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (supported: http, https, socks5, socks5h)", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: missing host", raw)
+	}
+	return u, nil
+}
+
+// FromSetting returns the Func for an explicit proxy setting. An empty setting
+// uses the environment, Direct disables proxies and anything else is the URL
+// of the proxy used for every address.
+func FromSetting(setting string) (Func, error) {
+	switch setting {
+	case "":
+		return FromEnvironment, nil
+	case Direct:
+		return func(string) (*url.URL, error) { return nil, nil }, nil
+	}
+
+	u, err := Parse(setting)
+	if err != nil {
+		return nil, err
+	}
+	return func(string) (*url.URL, error) { return u, nil }, nil
+}
+
+// FromEnvironment returns the proxy for addr set in HTTPS_PROXY, or in
+// ALL_PROXY when it's not set, unless NO_PROXY excludes addr. Loopback
+// addresses are never proxied.
+func FromEnvironment(addr string) (*url.URL, error) {
+	env := httpproxy.FromEnvironment()
+	if env.HTTPSProxy == "" {
+		env.HTTPSProxy = getenv("ALL_PROXY", "all_proxy")
+	}
+	u, err := env.ProxyFunc()(&url.URL{Scheme: "https", Host: addr})
+	if err != nil || u == nil {
+		return nil, err
+	}
+	return Parse(u.String())
+}
+
+func getenv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Dial connects to addr through the proxy at u.
+func Dial(ctx context.Context, u *url.URL, addr string) (net.Conn, error) {
+	switch u.Scheme {
+	case "http", "https":
+		return dialConnect(ctx, u, addr)
+	case "socks5", "socks5h":
+		return dialSOCKS5(ctx, u, addr)
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+}
+
+// dialConnect opens a tunnel to addr with an HTTP CONNECT request.
+func dialConnect(ctx context.Context, u *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := hostPort(u)
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %w", proxyAddr, err)
+	}
+	if u.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed TLS handshake with proxy %s: %w", proxyAddr, err)
+		}
+		conn = tlsConn
+	}
+
+	// The request and response must not outlive ctx.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT to proxy %s: %w", proxyAddr, err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response from proxy %s: %w", proxyAddr, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused CONNECT to %s: %s", proxyAddr, addr, resp.Status)
+	}
+
+	if !stop() {
+		return nil, ctx.Err()
+	}
+	if br.Buffered() > 0 {
+		return pipe.NewBufferedConn(conn, br), nil
+	}
+	return conn, nil
+}
+
+func dialSOCKS5(ctx context.Context, u *url.URL, addr string) (net.Conn, error) {
+	var auth *xproxy.Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = &xproxy.Auth{User: u.User.Username(), Password: password}
+	}
+
+	dialer, err := xproxy.SOCKS5("tcp", hostPort(u), auth, &net.Dialer{})
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialer.(xproxy.ContextDialer).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect through SOCKS5 proxy %s: %w", hostPort(u), err)
+	}
+	return conn, nil
+}
+
+// hostPort returns the address of the proxy at u, with the default port of
+// its scheme when none is given.
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "1080"
+	switch u.Scheme {
+	case "http":
+		port = "80"
+	case "https":
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
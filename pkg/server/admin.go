@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// registryCheckTimeout bounds how long the readiness probe waits for the
+// client registry lock before reporting it as unhealthy.
+const registryCheckTimeout = time.Second
+
+// readiness is the body of the readiness probe response.
+type readiness struct {
+	Status  string            `json:"status"`
+	Checks  map[string]string `json:"checks"`
+	Tunnels int               `json:"tunnels"`
+}
+
+// adminHandler serves the probes of the admin listener. /healthz reports
+// that the process is up, /readyz that it can serve tunnels.
+func (ts *TunnelServer) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("GET /readyz", ts.handleReady)
+	return mux
+}
+
+func (ts *TunnelServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	ready := readiness{
+		Status: "ok",
+		Checks: map[string]string{
+			"tunnel_listener": "ok",
+			"http_listener":   "ok",
+			"registry":        "ok",
+		},
+	}
+	fail := func(check, reason string) {
+		ready.Status = "unavailable"
+		ready.Checks[check] = reason
+	}
+
+	if !ts.tunnelListening.Load() {
+		fail("tunnel_listener", "not listening")
+	}
+	if ts.httpListener != nil && !ts.httpListening.Load() {
+		fail("http_listener", "not listening")
+	}
+	tunnels, ok := ts.countClients(registryCheckTimeout)
+	if ok {
+		ready.Tunnels = tunnels
+	} else {
+		fail("registry", "lock timeout")
+	}
+
+	status := http.StatusOK
+	if ready.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ready)
+}
+
+// countClients returns the number of connected tunnels, or false when the
+// registry can't be read within timeout.
+func (ts *TunnelServer) countClients(timeout time.Duration) (int, bool) {
+	count := make(chan int, 1)
+	go func() {
+		ts.mutex.RLock()
+		defer ts.mutex.RUnlock()
+		count <- len(ts.clients)
+	}()
+
+	select {
+	case n := <-count:
+		return n, true
+	case <-time.After(timeout):
+		return 0, false
+	}
+}
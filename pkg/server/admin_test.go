@@ -0,0 +1,128 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AYM1607/godig/pkg/auth"
+)
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name           string
+		listening      bool
+		lockRegistry   bool
+		expectedStatus int
+		expectedChecks map[string]string
+	}{
+		{
+			name:           "ready",
+			listening:      true,
+			expectedStatus: http.StatusOK,
+			expectedChecks: map[string]string{"tunnel_listener": "ok", "registry": "ok"},
+		},
+		{
+			name:           "not listening",
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedChecks: map[string]string{"tunnel_listener": "not listening", "http_listener": "not listening"},
+		},
+		{
+			name:           "registry stuck",
+			listening:      true,
+			lockRegistry:   true,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedChecks: map[string]string{"registry": "lock timeout"},
+		},
+	}
+
+	httpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer httpListener.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := &TunnelServer{
+				clients:      map[string]*ClientSession{"abcde": {ID: "abcde"}},
+				httpListener: httpListener,
+			}
+			ts.tunnelListening.Store(tt.listening)
+			ts.httpListening.Store(tt.listening)
+			if tt.lockRegistry {
+				ts.mutex.Lock()
+				defer ts.mutex.Unlock()
+			}
+
+			rec := httptest.NewRecorder()
+			ts.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+
+			var ready readiness
+			if err := json.NewDecoder(rec.Body).Decode(&ready); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			for check, expected := range tt.expectedChecks {
+				if ready.Checks[check] != expected {
+					t.Errorf("expected %s check %q, got %q", check, expected, ready.Checks[check])
+				}
+			}
+			if tt.expectedStatus == http.StatusOK && ready.Tunnels != 1 {
+				t.Errorf("expected 1 tunnel, got %d", ready.Tunnels)
+			}
+		})
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	rec := httptest.NewRecorder()
+	(&TunnelServer{}).adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d", rec.Code)
+	}
+}
+
+func TestReadyz_NoTunnelListener(t *testing.T) {
+	httpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := NewTunnelServer(Options{
+		HTTPListener: httpListener,
+		Auth:         auth.StaticKey("secret"),
+		Logger:       log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ts.Serve()
+	defer ts.Close()
+
+	// Wait for the public listener, the tunnel listener must never be ready.
+	var ready readiness
+	deadline := time.Now().Add(5 * time.Second)
+	for ready.Checks["http_listener"] != "ok" && time.Now().Before(deadline) {
+		rec := httptest.NewRecorder()
+		ts.adminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		ready = readiness{}
+		if err := json.NewDecoder(rec.Body).Decode(&ready); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+	}
+	if ready.Checks["http_listener"] != "ok" {
+		t.Fatal("expected the public listener to be ready")
+	}
+	if ready.Status == "ok" || ready.Checks["tunnel_listener"] != "not listening" {
+		t.Errorf("expected the server to be unavailable without a tunnel listener, got %+v", ready)
+	}
+}
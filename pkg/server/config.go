@@ -0,0 +1,89 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/AYM1607/godig/pkg/session"
+	"github.com/AYM1607/godig/types"
+)
+
+// Config holds the tunable settings of the public HTTP listener and the
+// limits applied to tunnels.
+type Config struct {
+	// ReadHeaderTimeout bounds the time a client has to send the request
+	// headers. This is the main protection against slowloris clients.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds the time to read the entire request, body included.
+	// Zero means no limit, which allows long uploads.
+	ReadTimeout time.Duration
+	// IdleTimeout is how long keep-alive connections are kept open between
+	// requests.
+	IdleTimeout time.Duration
+	// MaxHeaderBytes limits the size of the request line and headers.
+	MaxHeaderBytes int
+	// MaxRequestBodyBytes is the largest request body forwarded to a tunnel.
+	// Tunnels can ask for a lower limit in their handshake. Zero means no
+	// limit.
+	MaxRequestBodyBytes int64
+
+	// StreamIdleTimeout closes tunnel streams with no activity in either
+	// direction for this long, unless the tunnel asks for another value.
+	StreamIdleTimeout time.Duration
+	// MaxStreamIdleTimeout is the largest idle timeout a tunnel can ask for.
+	MaxStreamIdleTimeout time.Duration
+	// MaxRequestDuration caps the total duration of a single request. Tunnels
+	// can only ask for a lower value. Zero means no limit.
+	MaxRequestDuration time.Duration
+
+	// Session holds the default session settings, used unless a tunnel asks
+	// for others in its handshake.
+	Session types.SessionConfig
+	// MaxStreamWindowSize is the largest stream window a tunnel can ask for.
+	MaxStreamWindowSize uint32
+}
+
+// DefaultConfig returns the settings used by godig-server unless overridden.
+func DefaultConfig() Config {
+	return Config{
+		ReadHeaderTimeout:   10 * time.Second,
+		ReadTimeout:         0,
+		IdleTimeout:         120 * time.Second,
+		MaxHeaderBytes:      http.DefaultMaxHeaderBytes,
+		MaxRequestBodyBytes: 100 << 20,
+
+		StreamIdleTimeout:    60 * time.Second,
+		MaxStreamIdleTimeout: 10 * time.Minute,
+		MaxRequestDuration:   0,
+
+		Session:             session.Defaults(),
+		MaxStreamWindowSize: 16 << 20,
+	}
+}
+
+// QUICSessionConfig returns the settings QUIC listeners must be created with.
+// They are fixed before the handshake, so streams get the largest window a
+// tunnel could ask for.
+func (c Config) QUICSessionConfig() types.SessionConfig {
+	cfg := c.Session
+	cfg.MaxStreamWindowSize = c.MaxStreamWindowSize
+	return cfg
+}
+
+// newHTTPServer returns the public HTTP server configured with the limits in
+// cfg.
+func newHTTPServer(handler http.Handler, cfg Config) *http.Server {
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+
+	return &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		Protocols:         &protocols,
+	}
+}
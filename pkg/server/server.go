@@ -0,0 +1,811 @@
+// Package server implements the godig tunnel server. It accepts tunnels over
+// TCP, QUIC and WebSockets and routes public requests to them by subdomain, and
+// can be embedded in other programs or started on ephemeral ports in tests.
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/AYM1607/godig/pkg/accesslog"
+	"github.com/AYM1607/godig/pkg/audit"
+	"github.com/AYM1607/godig/pkg/auth"
+	"github.com/AYM1607/godig/pkg/deadline"
+	"github.com/AYM1607/godig/pkg/headers"
+	"github.com/AYM1607/godig/pkg/session"
+	"github.com/AYM1607/godig/pkg/telemetry"
+	"github.com/AYM1607/godig/pkg/transport"
+	"github.com/AYM1607/godig/types"
+)
+
+// TunnelInfo describes a tunnel to the hooks.
+type TunnelInfo struct {
+	ID         string
+	RemoteAddr net.Addr
+	// Identity is who the tunnel authenticated as.
+	Identity *auth.Identity
+	// Public is set for tunnels reachable without a bearer token.
+	Public bool
+}
+
+// Hooks are called on tunnel lifecycle events. They run on the connection
+// goroutine and must not block, nil hooks are skipped.
+type Hooks struct {
+	// OnConnect is called once a tunnel is registered, right before the
+	// client is told so.
+	OnConnect func(TunnelInfo)
+	// OnDisconnect is called once the session of a tunnel ended.
+	OnDisconnect func(TunnelInfo)
+	// OnReject is called when a handshake is refused.
+	OnReject func(remoteAddr net.Addr, err error)
+}
+
+// Options configures a TunnelServer. Listeners are optional, a server without
+// any can still be mounted on another HTTP server through Handler and get
+// tunnels over WebSockets.
+type Options struct {
+	// TunnelListener accepts tunnel connections over TCP.
+	TunnelListener net.Listener
+	// QUICListener accepts tunnel connections over QUIC. It must be created
+	// with Config.QUICSessionConfig.
+	QUICListener *transport.QUICListener
+	// HTTPListener accepts the public requests to the tunnels.
+	HTTPListener net.Listener
+	// TLSConfig, when set, serves HTTPListener over TLS.
+	TLSConfig *tls.Config
+
+	// Host is the domain tunnels are served under, as <tunnel-id>.<host>. It
+	// is also the host accepting tunnels over WebSockets. Defaults to
+	// localhost.
+	Host string
+	// Aliases are more domains tunnels are served under, tunnels connect over
+	// WebSockets on them too.
+	Aliases []string
+	// PublicPort is the HTTPS port public clients reach the tunnels on, as
+	// reported to the tunnels in their URL. The default port, 443, is used
+	// when zero.
+	PublicPort int
+	// Auth checks the credentials of connecting tunnels, it's required.
+	Auth auth.Authenticator
+	// Config holds the limits, DefaultConfig is used when zero.
+	Config Config
+	Hooks  Hooks
+
+	// AccessLog receives the access log of every public request, none is
+	// written when nil.
+	AccessLog slog.Handler
+	// Audit receives the tunnel lifecycle and authentication events, none
+	// are recorded when nil.
+	Audit audit.Sink
+	// Logger receives the connection and error logs of the server, the
+	// standard logger is used when nil.
+	Logger *log.Logger
+}
+
+type TunnelServer struct {
+	clients    map[string]*ClientSession
+	mutex      sync.RWMutex
+	host       string
+	aliases    []string
+	publicPort int
+	hooks      Hooks
+	logger     *log.Logger
+	audit      audit.Sink
+
+	// settingsMu guards the settings Reconfigure can change.
+	settingsMu sync.RWMutex
+	auth       auth.Authenticator
+	config     Config
+
+	tunnelListener net.Listener
+	quicListener   *transport.QUICListener
+	httpListener   net.Listener
+	httpServer     *http.Server
+	closeOnce      sync.Once
+	closed         atomic.Bool
+
+	// Set once the listeners are accepting connections, reported by the
+	// readiness probe.
+	tunnelListening atomic.Bool
+	httpListening   atomic.Bool
+}
+
+type ClientSession struct {
+	ID       string
+	Session  transport.Session
+	Bearer   *string
+	Identity *auth.Identity
+
+	// MaxRequestBodyBytes is the effective body limit for this tunnel, zero
+	// means no limit.
+	MaxRequestBodyBytes int64
+	// IdleTimeout and MaxRequestDuration are the negotiated stream limits,
+	// a zero MaxRequestDuration means no limit.
+	IdleTimeout        time.Duration
+	MaxRequestDuration time.Duration
+}
+
+func NewTunnelServer(opts Options) (*TunnelServer, error) {
+	if opts.Auth == nil {
+		return nil, errors.New("an authenticator is required")
+	}
+
+	cfg := opts.Config
+	if cfg == (Config{}) {
+		cfg = DefaultConfig()
+	}
+	host := opts.Host
+	if host == "" {
+		host = "localhost"
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	ts := &TunnelServer{
+		clients:    make(map[string]*ClientSession),
+		auth:       opts.Auth,
+		config:     cfg,
+		host:       host,
+		aliases:    opts.Aliases,
+		publicPort: opts.PublicPort,
+		hooks:      opts.Hooks,
+		logger:     logger,
+		audit:      opts.Audit,
+
+		tunnelListener: opts.TunnelListener,
+		quicListener:   opts.QUICListener,
+		httpListener:   opts.HTTPListener,
+	}
+
+	accessLog := opts.AccessLog
+	if accessLog == nil {
+		accessLog = slog.DiscardHandler
+	}
+	ts.httpServer = newHTTPServer(ts.handler(slog.New(accessLog)), cfg)
+	ts.httpServer.TLSConfig = opts.TLSConfig
+	ts.httpServer.ErrorLog = logger
+	return ts, nil
+}
+
+// Host returns the domain tunnels are served under.
+func (ts *TunnelServer) Host() string {
+	return ts.host
+}
+
+// TunnelURL returns the public URL of the tunnel with the given ID.
+func (ts *TunnelServer) TunnelURL(id string) string {
+	host := id + "." + ts.host
+	if ts.publicPort != 0 && ts.publicPort != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(ts.publicPort))
+	}
+	return "https://" + host
+}
+
+// Handler returns the handler of the public requests, with access logs,
+// tracing and the WebSocket tunnel endpoint. It's what HTTPListener is
+// served with, and can be mounted on another server instead.
+func (ts *TunnelServer) Handler() http.Handler {
+	return ts.httpServer.Handler
+}
+
+func (ts *TunnelServer) handler(accessLog *slog.Logger) http.Handler {
+	handler := accesslog.Handler(ts, accessLog, tunnelIDFromRequest, nil)
+	handler = telemetry.Handler(handler, "godig.server.request")
+	return ts.webSocketEndpoint(handler)
+}
+
+// Reconfigure applies cfg and authenticator to the tunnels connecting from
+// then on, connected tunnels keep their negotiated limits. The timeouts and
+// header limit of the public listener, and the session settings of the QUIC
+// listener, are fixed once created and need a new server.
+func (ts *TunnelServer) Reconfigure(cfg Config, authenticator auth.Authenticator) error {
+	if authenticator == nil {
+		return errors.New("an authenticator is required")
+	}
+
+	ts.settingsMu.Lock()
+	defer ts.settingsMu.Unlock()
+	ts.config = cfg
+	ts.auth = authenticator
+	return nil
+}
+
+// settings returns the current configuration and authenticator.
+func (ts *TunnelServer) settings() (Config, auth.Authenticator) {
+	ts.settingsMu.RLock()
+	defer ts.settingsMu.RUnlock()
+	return ts.config, ts.auth
+}
+
+// Audit records e in the audit log, stamped with the current time unless it
+// has one. Embedders use it for their own admin actions.
+func (ts *TunnelServer) Audit(e audit.Event) {
+	if ts.audit == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if err := ts.audit.Record(e); err != nil {
+		ts.logger.Printf("Failed to record audit event %s: %v", e.Type, err)
+	}
+}
+
+// AdminHandler returns the handler of the health and readiness probes.
+func (ts *TunnelServer) AdminHandler() http.Handler {
+	return ts.adminHandler()
+}
+
+// Serve accepts tunnel connections and public requests on the listeners in
+// the options. It returns when a listener fails, closing the server, or nil
+// once Close is called.
+func (ts *TunnelServer) Serve() error {
+	errs := make(chan error, 3)
+	serving := 0
+	if ts.tunnelListener != nil {
+		serving++
+		ts.tunnelListening.Store(true)
+		go func() { errs <- ts.serveTCP(ts.tunnelListener) }()
+	}
+	if ts.quicListener != nil {
+		serving++
+		ts.tunnelListening.Store(true)
+		go func() { errs <- ts.serveQUIC(ts.quicListener) }()
+	}
+	if ts.httpListener != nil {
+		serving++
+		go func() {
+			ts.httpListening.Store(true)
+			if ts.httpServer.TLSConfig != nil {
+				errs <- ts.httpServer.ServeTLS(ts.httpListener, "", "")
+			} else {
+				errs <- ts.httpServer.Serve(ts.httpListener)
+			}
+		}()
+	}
+	if serving == 0 {
+		return errors.New("no listener to serve")
+	}
+
+	err := <-errs
+	closed := ts.closed.Load()
+	ts.Close()
+	if closed && (errors.Is(err, net.ErrClosed) || errors.Is(err, http.ErrServerClosed)) {
+		return nil
+	}
+	return err
+}
+
+// Close stops the listeners and disconnects every tunnel.
+func (ts *TunnelServer) Close() error {
+	ts.closeOnce.Do(func() {
+		ts.closed.Store(true)
+		ts.tunnelListening.Store(false)
+		ts.httpListening.Store(false)
+
+		if ts.tunnelListener != nil {
+			ts.tunnelListener.Close()
+		}
+		if ts.quicListener != nil {
+			ts.quicListener.Close()
+		}
+		ts.httpServer.Close()
+
+		ts.mutex.Lock()
+		defer ts.mutex.Unlock()
+		for _, client := range ts.clients {
+			client.Session.Close()
+		}
+	})
+	return nil
+}
+
+// authTimeout bounds the authentication of a tunnel, which may call out to
+// another service.
+const authTimeout = 10 * time.Second
+
+// handleTunnelConnection runs the handshake with a client and serves its
+// tunnel until the session ends, whatever the transport.
+func (ts *TunnelServer) handleTunnelConnection(conn transport.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	var handshake types.HandshakeMessage
+	if err := conn.Decode(&handshake); err != nil {
+		ts.logger.Printf("Failed to read handshake: %v", err)
+		ts.Audit(audit.Event{
+			Type:       audit.HandshakeFailed,
+			RemoteAddr: conn.RemoteAddr().String(),
+			Reason:     fmt.Sprintf("invalid handshake: %v", err),
+		})
+		return
+	}
+
+	var identity *auth.Identity
+	reject := func(err error) {
+		ts.logger.Printf("Rejected tunnel from %s: %v", conn.RemoteAddr(), err)
+		event := audit.Event{
+			Type:       audit.HandshakeFailed,
+			TunnelID:   handshake.TunnelID,
+			RemoteAddr: conn.RemoteAddr().String(),
+			Public:     handshake.Bearer == nil,
+			Reason:     err.Error(),
+		}
+		if identity != nil {
+			event.Subject = identity.Subject
+		}
+		ts.Audit(event)
+		if ts.hooks.OnReject != nil {
+			ts.hooks.OnReject(conn.RemoteAddr(), err)
+		}
+	}
+
+	// The handshake goes through with the settings it started with.
+	cfg, authenticator := ts.settings()
+
+	authCtx, cancel := context.WithTimeout(context.Background(), authTimeout)
+	identity, err := authenticator.Authenticate(authCtx, auth.Request{
+		Handshake:  handshake,
+		RemoteAddr: conn.RemoteAddr(),
+		TLS:        conn.TLS(),
+	})
+	cancel()
+	if err != nil {
+		reject(err)
+		return
+	}
+	if identity == nil {
+		identity = &auth.Identity{}
+	}
+
+	// TODO: Validate max length.
+	if handshake.TunnelID == "" {
+		reject(errors.New("invalid tunnel ID in handshake"))
+		return
+	}
+	if !identity.CanRegister(handshake.TunnelID) {
+		reject(fmt.Errorf("%w: %s can't register tunnel %s", auth.ErrUnauthorized, identity.Subject, handshake.TunnelID))
+		return
+	}
+
+	idleTimeout, maxRequestDuration, err := streamLimits(cfg, handshake)
+	if err != nil {
+		reject(fmt.Errorf("invalid stream limits in handshake: %w", err))
+		return
+	}
+
+	requestedSession, err := session.Decode(handshake.Session)
+	if err != nil {
+		reject(fmt.Errorf("invalid session settings in handshake: %w", err))
+		return
+	}
+	sessionConfig := session.Negotiate(requestedSession, cfg.Session, cfg.MaxStreamWindowSize)
+
+	authMode := "authenticated"
+	if handshake.Bearer == nil {
+		authMode = "public (no auth)"
+	}
+
+	ts.logger.Printf("Client %s connecting with tunnel ID: %s (%s)", identity.Subject, handshake.TunnelID, authMode)
+
+	// The handshake deadline would otherwise cut the session.
+	conn.SetDeadline(time.Time{})
+
+	muxSession, err := conn.Session(sessionConfig)
+	if err != nil {
+		ts.logger.Printf("Failed to create session: %v", err)
+		return
+	}
+
+	// Register client
+	clientSession := &ClientSession{
+		ID:       handshake.TunnelID,
+		Session:  muxSession,
+		Bearer:   handshake.Bearer,
+		Identity: identity,
+
+		MaxRequestBodyBytes: bodyLimit(cfg, handshake.MaxRequestBodyBytes),
+		IdleTimeout:         idleTimeout,
+		MaxRequestDuration:  maxRequestDuration,
+	}
+
+	info := TunnelInfo{
+		ID:         handshake.TunnelID,
+		RemoteAddr: conn.RemoteAddr(),
+		Identity:   identity,
+		Public:     handshake.Bearer == nil,
+	}
+	event := audit.Event{
+		TunnelID:   handshake.TunnelID,
+		Subject:    identity.Subject,
+		RemoteAddr: conn.RemoteAddr().String(),
+		Public:     handshake.Bearer == nil,
+	}
+	if replaced := ts.registerClient(clientSession); replaced != nil {
+		replacedEvent := event
+		replacedEvent.Type = audit.SessionReplaced
+		replacedEvent.Reason = fmt.Sprintf("replaces the session of %s", replaced.Identity.Subject)
+		ts.Audit(replacedEvent)
+	}
+	event.Type = audit.TunnelRegistered
+	ts.Audit(event)
+	var expired atomic.Bool
+	defer func() {
+		ts.unregisterClient(clientSession)
+		event.Type = audit.TunnelDisconnected
+		if expired.Load() {
+			event.Reason = "credentials expired"
+		}
+		ts.Audit(event)
+		if ts.hooks.OnDisconnect != nil {
+			ts.hooks.OnDisconnect(info)
+		}
+	}()
+	if ts.hooks.OnConnect != nil {
+		ts.hooks.OnConnect(info)
+	}
+
+	// Send acknowledgment once the tunnel is routable, clients can take
+	// requests as soon as they get it. Nothing is sent over the session
+	// before the client reads it.
+	response := types.HandshakeResponse{
+		Status:      "ok",
+		URL:         ts.TunnelURL(handshake.TunnelID),
+		IdleTimeout: idleTimeout.String(),
+		Session:     session.Encode(sessionConfig),
+	}
+	if maxRequestDuration > 0 {
+		response.MaxRequestDuration = maxRequestDuration.String()
+	}
+	if err := conn.Encode(response); err != nil {
+		ts.logger.Printf("Failed to send handshake response: %v", err)
+		muxSession.Close()
+		return
+	}
+
+	ts.logger.Printf("Tunnel established for %s.%s", handshake.TunnelID, ts.host)
+
+	go func() {
+		err := session.Heartbeat(context.Background(), muxSession, sessionConfig.HeartbeatInterval, nil)
+		if err != nil {
+			ts.logger.Printf("Closing tunnel %s: %v", handshake.TunnelID, err)
+		}
+	}()
+
+	// Credentials are checked once, the session is cut when they expire.
+	if !identity.ExpiresAt.IsZero() {
+		expiry := time.AfterFunc(time.Until(identity.ExpiresAt), func() {
+			ts.logger.Printf("Closing tunnel %s: credentials of %s expired", handshake.TunnelID, identity.Subject)
+			expired.Store(true)
+			muxSession.Close()
+		})
+		defer expiry.Stop()
+	}
+
+	// Keep connection alive until client disconnects
+	<-muxSession.CloseChan()
+	ts.logger.Printf("Client %s disconnected", handshake.TunnelID)
+}
+
+func (ts *TunnelServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Extract tunnel ID from subdomain
+	host := r.Host
+	if strings.Contains(host, ":") {
+		host = strings.Split(host, ":")[0]
+	}
+
+	parts := strings.Split(host, ".")
+	if len(parts) < 2 {
+		http.Error(w, "Invalid subdomain", http.StatusBadRequest)
+		return
+	}
+
+	tunnelID := parts[0]
+	if tunnelID == "" {
+		http.Error(w, "Missing tunnel ID", http.StatusBadRequest)
+		return
+	}
+
+	client := ts.getClient(tunnelID)
+	if client == nil {
+		http.Error(w, "Tunnel not found or not connected", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Only validate bearer token if auth is enabled for this tunnel
+	if client.Bearer != nil {
+		token := getBearerToken(r)
+		if token != *client.Bearer {
+			reason := "invalid bearer token"
+			if token == "" {
+				reason = "missing bearer token"
+			}
+			ts.Audit(audit.Event{
+				Type:       audit.PublicAuthFailed,
+				TunnelID:   tunnelID,
+				Subject:    client.Identity.Subject,
+				RemoteAddr: r.RemoteAddr,
+				Reason:     reason,
+			})
+			http.Error(w, "Auth failed", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var body *limitedBody
+	if limit := client.MaxRequestBodyBytes; limit > 0 {
+		if r.ContentLength > limit {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit)}
+		r.Body = body
+	}
+
+	// Set by the access log, it identifies the request in error logs.
+	requestID := accesslog.RequestID(r.Context())
+	trace.SpanFromContext(r.Context()).SetAttributes(
+		attribute.String("godig.tunnel_id", tunnelID),
+		attribute.String("godig.request_id", requestID),
+	)
+
+	openCtx, openSpan := telemetry.StartSpan(r.Context(), "tunnel.open_stream")
+	rawStream, err := client.Session.OpenStream(openCtx)
+	telemetry.EndSpan(openSpan, err)
+	if err != nil {
+		ts.logger.Printf("Failed to open stream for %s: %v", tunnelID, err)
+		http.Error(w, "Failed to open tunnel stream", http.StatusBadGateway)
+		return
+	}
+	defer rawStream.Close()
+
+	// The deadline is renewed on every read and write, so only idle streams
+	// or requests over the maximum duration are cut.
+	stream := deadline.NewConn(rawStream, client.IdleTimeout, client.MaxRequestDuration)
+
+	// HTTP/1.x requests are half duplex by default, allow reading the body
+	// while the response is being written for bidirectional streams. HTTP/2
+	// always supports it.
+	if err := http.NewResponseController(w).EnableFullDuplex(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		ts.logger.Printf("Failed to enable full duplex for %s: %v", tunnelID, err)
+	}
+
+	outReq := tunnelRequest(r)
+
+	// Forward the HTTP request to the client concurrently with reading the
+	// response, so streaming calls like gRPC bidi streams don't deadlock.
+	writeErrCh := make(chan error, 1)
+	go func() {
+		err := outReq.Write(stream)
+		writeErrCh <- err
+		if err != nil {
+			// The client may still be waiting for the rest of the body, don't
+			// leave the response read hanging on it.
+			stream.Abort()
+		}
+	}()
+
+	// Read the HTTP response from the client.
+	streamReader := bufio.NewReader(stream)
+	resp, err := http.ReadResponse(streamReader, outReq)
+	if err != nil {
+		select {
+		case writeErr := <-writeErrCh:
+			if body != nil && body.exceeded.Load() {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if writeErr != nil {
+				ts.logger.Printf("Failed to write request %s to stream: %v", requestID, writeErr)
+				http.Error(w, "Failed to forward request", http.StatusBadGateway)
+				return
+			}
+		default:
+		}
+		ts.logger.Printf("Failed to read response to %s from stream: %v", requestID, err)
+		// The stream was idle for too long or hit the maximum request
+		// duration.
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			http.Error(w, "Tunnel response timed out", http.StatusGatewayTimeout)
+			return
+		}
+		http.Error(w, "Failed to read response", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		ts.logger.Printf("Switching protocols for %s", tunnelID)
+		if err := ts.handleUpgrade(w, resp, streamReader, stream); err != nil {
+			ts.logger.Printf("Error handling upgraded connection for %s: %v", requestID, err)
+		}
+		return
+	}
+
+	// Hop-by-hop headers only apply to the connection with the tunnel.
+	headers.RemoveHopByHopHeaders(resp.Header)
+
+	// Copy response headers
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+
+	announceTrailers(w.Header(), resp)
+
+	// Send headers before streaming the response to handle SSE gracefully.
+	w.WriteHeader(resp.StatusCode)
+
+	if isStreamingResponse(resp) {
+		ts.logger.Printf("Handling streaming response for %s", tunnelID)
+		if err := ts.handleStreamingResponse(w, resp, stream); err != nil {
+			ts.logger.Printf("Error handling streaming response to %s: %v", requestID, err)
+			return
+		}
+	} else {
+		_, err = io.Copy(w, resp.Body)
+		if err != nil {
+			ts.logger.Printf("Error copying response body to %s: %v", requestID, err)
+			return
+		}
+	}
+
+	copyTrailers(w.Header(), resp)
+}
+
+// tunnelIDFromRequest returns the tunnel ID in the subdomain of r, or an
+// empty string.
+func tunnelIDFromRequest(r *http.Request) string {
+	host, _, _ := strings.Cut(r.Host, ":")
+	id, _, ok := strings.Cut(host, ".")
+	if !ok {
+		return ""
+	}
+	return id
+}
+
+// tunnelRequest returns the request forwarded through the tunnel, a copy of r
+// with hop-by-hop headers removed and the proxy, trace and connection address
+// headers added.
+func tunnelRequest(r *http.Request) *http.Request {
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+
+	out := r.Clone(r.Context())
+	headers.PrepareRequest(out, clientIP)
+	telemetry.Inject(r.Context(), out.Header)
+
+	// Public clients can't set the connection addresses.
+	out.Header.Set(headers.ClientAddrHeader, r.RemoteAddr)
+	out.Header.Del(headers.PublicAddrHeader)
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		out.Header.Set(headers.PublicAddrHeader, addr.String())
+	}
+	return out
+}
+
+// limitedBody is a request body cut by http.MaxBytesReader that records
+// whether it went over the limit. Request.Write hides the error behind an
+// unexported type.
+type limitedBody struct {
+	io.ReadCloser
+	exceeded atomic.Bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		b.exceeded.Store(true)
+	}
+	return n, err
+}
+
+// bodyLimit returns the request body limit for a tunnel that asked for the
+// given limit in its handshake. Tunnels can only lower the server limit.
+func bodyLimit(cfg Config, requested int64) int64 {
+	limit := cfg.MaxRequestBodyBytes
+	if requested > 0 && (limit == 0 || requested < limit) {
+		limit = requested
+	}
+	return limit
+}
+
+// streamLimits negotiates the stream idle timeout and maximum request
+// duration asked for in the handshake against the server limits.
+func streamLimits(cfg Config, handshake types.HandshakeMessage) (time.Duration, time.Duration, error) {
+	idleTimeout := cfg.StreamIdleTimeout
+	if handshake.IdleTimeout != "" {
+		requested, err := time.ParseDuration(handshake.IdleTimeout)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid idle timeout: %w", err)
+		}
+		if requested > 0 {
+			idleTimeout = min(requested, cfg.MaxStreamIdleTimeout)
+		}
+	}
+
+	maxRequestDuration := cfg.MaxRequestDuration
+	if handshake.MaxRequestDuration != "" {
+		requested, err := time.ParseDuration(handshake.MaxRequestDuration)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid max request duration: %w", err)
+		}
+		if requested > 0 && (maxRequestDuration == 0 || requested < maxRequestDuration) {
+			maxRequestDuration = requested
+		}
+	}
+
+	return idleTimeout, maxRequestDuration, nil
+}
+
+// registerClient makes client routable, returning the session it replaced,
+// if any.
+func (ts *TunnelServer) registerClient(client *ClientSession) *ClientSession {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	// Close existing session if any.
+	existing, exists := ts.clients[client.ID]
+	if exists {
+		ts.logger.Printf("Replacing existing session for tunnel ID: %s", client.ID)
+		// TODO: Handle these errors.
+		existing.Session.Close()
+	}
+
+	ts.clients[client.ID] = client
+	return existing
+}
+
+// unregisterClient removes client, unless it was already replaced.
+func (ts *TunnelServer) unregisterClient(client *ClientSession) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	if ts.clients[client.ID] == client {
+		delete(ts.clients, client.ID)
+	}
+}
+
+func (ts *TunnelServer) getClient(tunnelID string) *ClientSession {
+	ts.mutex.RLock()
+	defer ts.mutex.RUnlock()
+	return ts.clients[tunnelID]
+}
+
+func getBearerToken(r *http.Request) string {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return ""
+	}
+
+	// Check if it starts with "Bearer "
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return ""
+	}
+
+	// Extract the token part
+	return strings.TrimPrefix(authHeader, "Bearer ")
+}
@@ -0,0 +1,624 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/AYM1607/godig/pkg/audit"
+	"github.com/AYM1607/godig/pkg/auth"
+	"github.com/AYM1607/godig/pkg/headers"
+	"github.com/AYM1607/godig/pkg/tunnel"
+	"github.com/AYM1607/godig/types"
+)
+
+func TestTunnelServer(t *testing.T) {
+	tunnelListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var events []string
+	record := func(event string) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	}
+
+	ts, err := NewTunnelServer(Options{
+		TunnelListener: tunnelListener,
+		Host:           "godig.test",
+		Auth:           auth.StaticKey("secret"),
+		Logger:         log.New(io.Discard, "", 0),
+		Hooks: Hooks{
+			OnConnect:    func(info TunnelInfo) { record("connect " + info.ID) },
+			OnDisconnect: func(info TunnelInfo) { record("disconnect " + info.ID) },
+			OnReject:     func(net.Addr, error) { record("reject") },
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- ts.Serve() }()
+
+	// The public handler is mounted on another mux.
+	mux := http.NewServeMux()
+	mux.Handle("/", ts.Handler())
+	public := httptest.NewServer(mux)
+	defer public.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := tunnel.Open(ctx, tunnel.Options{Server: tunnelListener.Addr().String(), APIKey: "wrong"}); err == nil {
+		t.Error("expected a tunnel with a wrong API key to be rejected")
+	}
+
+	tun, err := tunnel.Open(ctx, tunnel.Options{
+		Server:   tunnelListener.Addr().String(),
+		APIKey:   "secret",
+		TunnelID: "abcde",
+		Bearer:   "token",
+	})
+	if err != nil {
+		t.Fatalf("failed to open tunnel: %v", err)
+	}
+	defer tun.Close()
+	if tun.URL() != "https://abcde.godig.test" {
+		t.Errorf("unexpected tunnel URL %q", tun.URL())
+	}
+	go http.Serve(tun, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello from "+r.Host)
+	}))
+
+	tests := []struct {
+		name           string
+		host           string
+		bearer         string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "authorized", host: "abcde.godig.test", bearer: "token", expectedStatus: http.StatusOK, expectedBody: "hello from abcde.godig.test"},
+		{name: "wrong bearer", host: "abcde.godig.test", bearer: "wrong", expectedStatus: http.StatusUnauthorized},
+		{name: "unknown tunnel", host: "other.godig.test", bearer: "token", expectedStatus: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, public.URL, nil)
+			req.Host = tt.host
+			req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+			if tt.expectedBody != "" && string(body) != tt.expectedBody {
+				t.Errorf("unexpected body %q", body)
+			}
+		})
+	}
+
+	ts.Close()
+	if err := <-served; err != nil {
+		t.Errorf("expected Serve to return nil once closed, got %v", err)
+	}
+	// The disconnect hook runs once the connection goroutine notices.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(events)
+		mu.Unlock()
+		if n >= 3 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"reject", "connect abcde", "disconnect abcde"}
+	if len(events) != len(expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("expected events %v, got %v", expected, events)
+			break
+		}
+	}
+}
+
+func TestTunnelServer_TunnelURL(t *testing.T) {
+	tests := []struct {
+		port     int
+		expected string
+	}{
+		{port: 0, expected: "https://abcde.godig.test"},
+		{port: 443, expected: "https://abcde.godig.test"},
+		{port: 8443, expected: "https://abcde.godig.test:8443"},
+	}
+	for _, tt := range tests {
+		ts, err := NewTunnelServer(Options{Host: "godig.test", PublicPort: tt.port, Auth: auth.StaticKey("secret")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if url := ts.TunnelURL("abcde"); url != tt.expected {
+			t.Errorf("expected %q with port %d, got %q", tt.expected, tt.port, url)
+		}
+	}
+}
+
+func TestNewTunnelServer_RequiresAuth(t *testing.T) {
+	if _, err := NewTunnelServer(Options{Config: DefaultConfig()}); err == nil {
+		t.Error("expected an error without an authenticator")
+	}
+	ts, err := NewTunnelServer(Options{Auth: auth.StaticKey("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Host() != "localhost" || ts.config != DefaultConfig() {
+		t.Errorf("expected the defaults, got host %q and config %+v", ts.Host(), ts.config)
+	}
+	if err := ts.Serve(); err == nil {
+		t.Error("expected Serve to fail without listeners")
+	}
+}
+
+// serveTunnel runs a server with cfg and a tunnel served by handler, and
+// returns the public URL of the server. Requests must use the abcde.godig.test
+// host.
+func serveTunnel(t *testing.T, cfg Config, handler http.HandlerFunc) string {
+	t.Helper()
+	publicURL, tun := openTunnel(t, cfg, "")
+	go http.Serve(tun, handler)
+	return publicURL
+}
+
+// openTunnel runs a server with cfg and opens the abcde tunnel to it,
+// forwarding to the local service at localAddr when set. It returns the
+// public URL of the server and the tunnel.
+func openTunnel(t *testing.T, cfg Config, localAddr string) (string, *tunnel.Tunnel) {
+	t.Helper()
+	tunnelListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := NewTunnelServer(Options{
+		TunnelListener: tunnelListener,
+		Host:           "godig.test",
+		Auth:           auth.StaticKey("secret"),
+		Config:         cfg,
+		Logger:         log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ts.Serve()
+	t.Cleanup(func() { ts.Close() })
+	public := httptest.NewServer(ts.Handler())
+	t.Cleanup(public.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tun, err := tunnel.Open(ctx, tunnel.Options{
+		Server:    tunnelListener.Addr().String(),
+		APIKey:    "secret",
+		TunnelID:  "abcde",
+		LocalAddr: localAddr,
+		Config:    types.TunnelClientConfig{DisableAuth: true},
+	})
+	if err != nil {
+		t.Fatalf("failed to open tunnel: %v", err)
+	}
+	t.Cleanup(func() { tun.Close() })
+	return public.URL, tun
+}
+
+// publicRequest sends a request with body to the tunnel behind publicURL and
+// returns the response status. A negative contentLength hides the length of
+// the body.
+func publicRequest(t *testing.T, publicURL string, body string, contentLength int64) int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, publicURL, io.NopCloser(strings.NewReader(body)))
+	req.Host = "abcde.godig.test"
+	req.ContentLength = contentLength
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode
+}
+
+func TestTunnelServer_BodyLimit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxRequestBodyBytes = 10
+	var calls atomic.Int32
+	publicURL := serveTunnel(t, cfg, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		io.Copy(io.Discard, r.Body)
+	})
+
+	if status := publicRequest(t, publicURL, "0123456789", 10); status != http.StatusOK {
+		t.Errorf("expected status 200 for a body at the limit, got %d", status)
+	}
+	if status := publicRequest(t, publicURL, "0123456789a", 11); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413 for a body over the limit, got %d", status)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected the local service to only get the body within the limit, got %d calls", n)
+	}
+
+	// Bodies of unknown length are cut once they go over the limit.
+	if status := publicRequest(t, publicURL, "0123456789a", -1); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status 413 for a streamed body over the limit, got %d", status)
+	}
+}
+
+func TestTunnelServer_ResponseTimeout(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StreamIdleTimeout = 200 * time.Millisecond
+	release := make(chan struct{})
+	defer close(release)
+	publicURL := serveTunnel(t, cfg, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+
+	start := time.Now()
+	if status := publicRequest(t, publicURL, "", 0); status != http.StatusGatewayTimeout {
+		t.Errorf("expected status 504 for a stalled local service, got %d", status)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the idle timeout to cut the request, took %v", elapsed)
+	}
+}
+
+func TestTunnelServer_UpstreamKeepAlive(t *testing.T) {
+	var conns atomic.Int32
+	local := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	local.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	local.Start()
+	defer local.Close()
+	publicURL, _ := openTunnel(t, DefaultConfig(), local.URL)
+
+	for range 5 {
+		if status := publicRequest(t, publicURL, "", 0); status != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", status)
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("expected the requests to reuse one local connection, got %d", n)
+	}
+}
+
+func TestTunnelServer_Upgrade(t *testing.T) {
+	// Switches to an echo protocol, like a WebSocket server.
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			t.Errorf("expected the Upgrade header to reach the local service, got %q", r.Header.Get("Upgrade"))
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("failed to hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		io.Copy(conn, brw)
+	}))
+	defer local.Close()
+	publicURL, _ := openTunnel(t, DefaultConfig(), local.URL)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(publicURL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, _ := http.NewRequest(http.MethodGet, publicURL, nil)
+	req.Host = "abcde.godig.test"
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("failed to write request: %v", err)
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "echo" {
+		t.Fatalf("expected the protocol switch, got %d %v", resp.StatusCode, resp.Header)
+	}
+
+	// Both directions stay open after the switch.
+	for _, message := range []string{"one", "two"} {
+		io.WriteString(conn, message+"\n")
+		reply, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read the echo of %q: %v", message, err)
+		}
+		if reply != message+"\n" {
+			t.Errorf("unexpected echo %q", reply)
+		}
+	}
+}
+
+func TestTunnelRequest_ConnectionAddrs(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://abcde.godig.xyz/", nil)
+	req.RemoteAddr = "203.0.113.7:51234"
+	public := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443}
+	req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, public))
+	// Spoofed by the public client.
+	req.Header.Set(headers.ClientAddrHeader, "192.0.2.1:1")
+	req.Header.Set(headers.PublicAddrHeader, "192.0.2.2:2")
+
+	out := tunnelRequest(req)
+	if got := out.Header.Get(headers.ClientAddrHeader); got != "203.0.113.7:51234" {
+		t.Errorf("expected the public client address, got %q", got)
+	}
+	if got := out.Header.Get(headers.PublicAddrHeader); got != "198.51.100.1:443" {
+		t.Errorf("expected the public listener address, got %q", got)
+	}
+}
+
+func TestTunnelServer_Identity(t *testing.T) {
+	tunnelListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connected := make(chan TunnelInfo, 1)
+	ts, err := NewTunnelServer(Options{
+		TunnelListener: tunnelListener,
+		Auth: auth.Func(func(ctx context.Context, req auth.Request) (*auth.Identity, error) {
+			if req.RemoteAddr == nil {
+				t.Error("expected the remote address of the tunnel")
+			}
+			return &auth.Identity{Subject: "alice", TunnelIDs: []string{"alice-*"}}, nil
+		}),
+		Logger: log.New(io.Discard, "", 0),
+		Hooks:  Hooks{OnConnect: func(info TunnelInfo) { connected <- info }},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ts.Serve()
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := tunnel.Open(ctx, tunnel.Options{Server: tunnelListener.Addr().String(), TunnelID: "bob"}); err == nil {
+		t.Error("expected the identity to be refused tunnel bob")
+	}
+
+	tun, err := tunnel.Open(ctx, tunnel.Options{Server: tunnelListener.Addr().String(), TunnelID: "alice-api"})
+	if err != nil {
+		t.Fatalf("failed to open tunnel: %v", err)
+	}
+	defer tun.Close()
+
+	if info := <-connected; info.ID != "alice-api" || info.Identity.Subject != "alice" {
+		t.Errorf("unexpected tunnel info %+v", info)
+	}
+}
+
+func TestTunnelServer_ExpiredCredentials(t *testing.T) {
+	tunnelListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disconnected := make(chan TunnelInfo, 1)
+	ts, err := NewTunnelServer(Options{
+		TunnelListener: tunnelListener,
+		Auth: auth.Func(func(ctx context.Context, req auth.Request) (*auth.Identity, error) {
+			return &auth.Identity{Subject: "alice", ExpiresAt: time.Now().Add(200 * time.Millisecond)}, nil
+		}),
+		Logger: log.New(io.Discard, "", 0),
+		Hooks:  Hooks{OnDisconnect: func(info TunnelInfo) { disconnected <- info }},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ts.Serve()
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tun, err := tunnel.Open(ctx, tunnel.Options{Server: tunnelListener.Addr().String(), TunnelID: "alice"})
+	if err != nil {
+		t.Fatalf("failed to open tunnel: %v", err)
+	}
+	defer tun.Close()
+
+	select {
+	case info := <-disconnected:
+		if info.ID != "alice" {
+			t.Errorf("unexpected tunnel info %+v", info)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the session to be closed once the credentials expired")
+	}
+}
+
+// auditRecorder is an audit.Sink keeping the events in memory.
+type auditRecorder struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+func (r *auditRecorder) Record(e audit.Event) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+// find returns the first event of type typ, waiting for it up to 5 seconds.
+func (r *auditRecorder) find(typ string) *audit.Event {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		for _, e := range r.events {
+			if e.Type == typ {
+				r.mu.Unlock()
+				return &e
+			}
+		}
+		r.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func TestTunnelServer_Audit(t *testing.T) {
+	tunnelListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recorder := &auditRecorder{}
+	ts, err := NewTunnelServer(Options{
+		TunnelListener: tunnelListener,
+		Host:           "godig.test",
+		Auth:           auth.StaticKey("secret"),
+		Logger:         log.New(io.Discard, "", 0),
+		Audit:          recorder,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ts.Serve()
+	defer ts.Close()
+	public := httptest.NewServer(ts.Handler())
+	defer public.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tunnel.Open(ctx, tunnel.Options{Server: tunnelListener.Addr().String(), APIKey: "wrong", TunnelID: "abcde"})
+	first, err := tunnel.Open(ctx, tunnel.Options{Server: tunnelListener.Addr().String(), APIKey: "secret", TunnelID: "abcde", Bearer: "token"})
+	if err != nil {
+		t.Fatalf("failed to open tunnel: %v", err)
+	}
+	defer first.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, public.URL, nil)
+	req.Host = "abcde.godig.test"
+	req.Header.Set("Authorization", "Bearer wrong")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	second, err := tunnel.Open(ctx, tunnel.Options{Server: tunnelListener.Addr().String(), APIKey: "secret", TunnelID: "abcde", Bearer: "token"})
+	if err != nil {
+		t.Fatalf("failed to open tunnel: %v", err)
+	}
+	first.Close()
+	second.Close()
+
+	tests := []struct {
+		typ    string
+		reason string
+	}{
+		{typ: audit.HandshakeFailed, reason: "unauthorized: invalid API key"},
+		{typ: audit.TunnelRegistered},
+		{typ: audit.PublicAuthFailed, reason: "invalid bearer token"},
+		{typ: audit.SessionReplaced, reason: "replaces the session of api-key"},
+		{typ: audit.TunnelDisconnected},
+	}
+	for _, tt := range tests {
+		e := recorder.find(tt.typ)
+		if e == nil {
+			t.Errorf("expected a %s event", tt.typ)
+			continue
+		}
+		if e.TunnelID != "abcde" || e.Reason != tt.reason || e.Time.IsZero() {
+			t.Errorf("unexpected %s event %+v", tt.typ, e)
+		}
+		if tt.typ != audit.HandshakeFailed && e.Subject != "api-key" {
+			t.Errorf("expected the %s event to name the subject, got %+v", tt.typ, e)
+		}
+	}
+}
+
+func TestTunnelServer_Reconfigure(t *testing.T) {
+	tunnelListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := NewTunnelServer(Options{
+		TunnelListener: tunnelListener,
+		Auth:           auth.StaticKey("old"),
+		Logger:         log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go ts.Serve()
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tun, err := tunnel.Open(ctx, tunnel.Options{Server: tunnelListener.Addr().String(), APIKey: "old"})
+	if err != nil {
+		t.Fatalf("failed to open tunnel: %v", err)
+	}
+	defer tun.Close()
+
+	if err := ts.Reconfigure(DefaultConfig(), nil); err == nil {
+		t.Error("expected an error without an authenticator")
+	}
+	cfg := DefaultConfig()
+	cfg.MaxRequestBodyBytes = 1024
+	if err := ts.Reconfigure(cfg, auth.StaticKey("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tunnel.Open(ctx, tunnel.Options{Server: tunnelListener.Addr().String(), APIKey: "old"}); err == nil {
+		t.Error("expected the old API key to be refused")
+	}
+	reconfigured, err := tunnel.Open(ctx, tunnel.Options{Server: tunnelListener.Addr().String(), APIKey: "new"})
+	if err != nil {
+		t.Fatalf("failed to open tunnel: %v", err)
+	}
+	defer reconfigured.Close()
+
+	if limit := ts.getClient(reconfigured.ID()).MaxRequestBodyBytes; limit != 1024 {
+		t.Errorf("expected the new body limit, got %d", limit)
+	}
+	if limit := ts.getClient(tun.ID()).MaxRequestBodyBytes; limit != DefaultConfig().MaxRequestBodyBytes {
+		t.Errorf("expected the connected tunnel to keep its limit, got %d", limit)
+	}
+}
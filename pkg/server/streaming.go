@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AYM1607/godig/pkg/deadline"
+)
+
+// streamingMediaTypes are consumed incrementally by clients, so their bodies
+// are flushed as soon as any data arrives. Entries ending in "+" match any
+// suffix, e.g. application/grpc+proto.
+var streamingMediaTypes = []string{
+	"text/event-stream",
+	"application/grpc",
+	"application/grpc+",
+	"application/grpc-web",
+	"application/grpc-web+",
+	"application/grpc-web-text",
+	"application/grpc-web-text+",
+	"application/x-ndjson",
+	"application/ndjson",
+	"application/jsonl",
+	"application/jsonlines",
+	"application/x-jsonlines",
+	"application/stream+json",
+}
+
+// keepaliveInterval is under most browser/client/proxy timeouts.
+const keepaliveInterval = 25 * time.Second
+
+// isStreamingResponse reports whether the response body must be flushed on
+// every write instead of being buffered by the response writer.
+func isStreamingResponse(resp *http.Response) bool {
+	if isStreamingMediaType(resp.Header.Get("Content-Type")) {
+		return true
+	}
+
+	// http.ReadResponse moves Transfer-Encoding out of the header map.
+	if slices.Contains(resp.TransferEncoding, "chunked") {
+		return true
+	}
+
+	// Bodies without a known length are delimited by the end of the stream,
+	// and are often produced incrementally.
+	return resp.ContentLength < 0
+}
+
+func isStreamingMediaType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range streamingMediaTypes {
+		if mediaType == t || (strings.HasSuffix(t, "+") && strings.HasPrefix(mediaType, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+func isEventStream(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// announceTrailers declares the trailers of resp before the headers are
+// written so clients know to expect them.
+func announceTrailers(header http.Header, resp *http.Response) {
+	for key := range resp.Trailer {
+		header.Add("Trailer", key)
+	}
+}
+
+// copyTrailers sends the trailers of resp. It must be called after the body
+// has been fully read, which is when the trailer values are known.
+func copyTrailers(header http.Header, resp *http.Response) {
+	announced := header.Values("Trailer")
+	for key, values := range resp.Trailer {
+		if !slices.Contains(announced, key) {
+			key = http.TrailerPrefix + key
+		}
+		header[key] = values
+	}
+}
+
+// handleStreamingResponse copies the response body flushing after every
+// write. Event streams also get keep-alive comments while idle to prevent
+// connections from being closed.
+func (ts *TunnelServer) handleStreamingResponse(w http.ResponseWriter, resp *http.Response, stream *deadline.Conn) error {
+	rc := http.NewResponseController(w)
+
+	// Send the headers right away, the first chunk may take a while.
+	if err := rc.Flush(); err != nil {
+		return fmt.Errorf("response writer doesn't support flushing: %w", err)
+	}
+
+	// Mutex to protect concurrent writes to the response writer.
+	var writeMutex sync.Mutex
+	// Keep-alive comments can only be sent between events. Both are guarded
+	// by writeMutex.
+	atEventBoundary := true
+	lastWrite := time.Now()
+
+	done := make(chan error, 1)
+
+	go func() {
+		buf := make([]byte, 32*1024) // 32KB buffer for better performance
+		for {
+			n, err := resp.Body.Read(buf)
+			if n > 0 {
+				writeMutex.Lock()
+				_, writeErr := w.Write(buf[:n])
+				if writeErr == nil {
+					writeErr = rc.Flush()
+				}
+				atEventBoundary = bytes.HasSuffix(buf[:n], []byte("\n\n")) ||
+					bytes.HasSuffix(buf[:n], []byte("\r\n\r\n"))
+				lastWrite = time.Now()
+				writeMutex.Unlock()
+
+				if writeErr != nil {
+					done <- writeErr
+					return
+				}
+			}
+			if err != nil {
+				if err == io.EOF {
+					done <- nil
+				} else {
+					done <- err
+				}
+				return
+			}
+		}
+	}()
+
+	if !isEventStream(resp) {
+		return <-done
+	}
+
+	keepaliveTicker := time.NewTicker(keepaliveInterval)
+	defer keepaliveTicker.Stop()
+
+	for {
+		select {
+		case <-keepaliveTicker.C:
+			writeMutex.Lock()
+			if !atEventBoundary || time.Since(lastWrite) < keepaliveInterval {
+				writeMutex.Unlock()
+				continue
+			}
+			// SSE comments are ignored by browsers but keep the connection alive.
+			_, err := w.Write([]byte(": keepalive\n\n"))
+			if err == nil {
+				err = rc.Flush()
+			}
+			lastWrite = time.Now()
+			writeMutex.Unlock()
+
+			if err != nil {
+				// Unblock the copying goroutine before returning so it doesn't
+				// write to the response after the handler is done.
+				resp.Body.Close()
+				<-done
+				return err
+			}
+
+			// Count the keepalive as activity so idle event streams are not
+			// closed by the multiplexer.
+			stream.Touch()
+		case err := <-done:
+			return err
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func readResponse(t *testing.T, raw string) *http.Response {
+	t.Helper()
+	resp, err := http.ReadResponse(bufio.NewReader(strings.NewReader(raw)), nil)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	return resp
+}
+
+func TestIsStreamingResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		expected bool
+	}{
+		{
+			name:     "content length",
+			raw:      "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Length: 2\r\n\r\nok",
+			expected: false,
+		},
+		{
+			name:     "keep-alive is not streaming",
+			raw:      "HTTP/1.1 200 OK\r\nConnection: keep-alive\r\nContent-Length: 2\r\n\r\nok",
+			expected: false,
+		},
+		{
+			name:     "chunked",
+			raw:      "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n0\r\n\r\n",
+			expected: true,
+		},
+		{
+			name:     "unknown length",
+			raw:      "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\nok",
+			expected: true,
+		},
+		{
+			name:     "server sent events",
+			raw:      "HTTP/1.1 200 OK\r\nContent-Type: text/event-stream; charset=utf-8\r\nContent-Length: 2\r\n\r\nok",
+			expected: true,
+		},
+		{
+			name:     "grpc-web",
+			raw:      "HTTP/1.1 200 OK\r\nContent-Type: application/grpc-web+proto\r\nContent-Length: 2\r\n\r\nok",
+			expected: true,
+		},
+		{
+			name:     "ndjson",
+			raw:      "HTTP/1.1 200 OK\r\nContent-Type: application/x-ndjson\r\nContent-Length: 2\r\n\r\nok",
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := readResponse(t, tt.raw)
+			if got := isStreamingResponse(resp); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCopyTrailers(t *testing.T) {
+	resp := readResponse(t, "HTTP/1.1 200 OK\r\n"+
+		"Transfer-Encoding: chunked\r\n"+
+		"Trailer: Grpc-Status\r\n\r\n"+
+		"2\r\nok\r\n0\r\n"+
+		"Grpc-Status: 0\r\n"+
+		"Grpc-Message: done\r\n\r\n")
+
+	rec := httptest.NewRecorder()
+	announceTrailers(rec.Header(), resp)
+	rec.WriteHeader(resp.StatusCode)
+	if _, err := rec.Body.ReadFrom(resp.Body); err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	copyTrailers(rec.Header(), resp)
+
+	trailer := rec.Result().Trailer
+	if got := trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("expected announced trailer Grpc-Status to be 0, got %q", got)
+	}
+	if got := trailer.Get("Grpc-Message"); got != "done" {
+		t.Errorf("expected undeclared trailer Grpc-Message to be done, got %q", got)
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/AYM1607/godig/pkg/transport"
+)
+
+// serveTCP accepts tunnel connections on listener until it's closed.
+func (ts *TunnelServer) serveTCP(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			ts.logger.Printf("Failed to accept connection: %v", err)
+			continue
+		}
+
+		go ts.handleTunnelConnection(transport.NewServerConn(conn))
+	}
+}
+
+// serveQUIC accepts tunnel connections over QUIC until listener is closed.
+func (ts *TunnelServer) serveQUIC(listener *transport.QUICListener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go ts.handleTunnelConnection(conn)
+	}
+}
+
+// webSocketEndpoint accepts tunnel connections over a WebSocket on the server
+// host and its aliases, for clients that can only reach the public HTTPS port. Every other
+// request goes to h.
+func (ts *TunnelServer) webSocketEndpoint(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if r.URL.Path != transport.WebSocketPath || !ts.servesHost(host) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		// The public listener timeouts don't apply to tunnel connections.
+		rc := http.NewResponseController(w)
+		rc.SetReadDeadline(time.Time{})
+		rc.SetWriteDeadline(time.Time{})
+
+		conn, err := transport.AcceptWebSocket(w, r)
+		if err != nil {
+			ts.logger.Printf("Failed to accept WebSocket tunnel connection from %s: %v", r.RemoteAddr, err)
+			return
+		}
+		ts.handleTunnelConnection(conn)
+	})
+}
+
+// servesHost reports whether host is the server host or one of its aliases.
+func (ts *TunnelServer) servesHost(host string) bool {
+	return host == ts.host || slices.Contains(ts.aliases, host)
+}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/AYM1607/godig/pkg/pipe"
+)
+
+// handleUpgrade completes a protocol switch, like a WebSocket handshake, and
+// then passes bytes through between the public connection and the stream.
+// streamReader holds the data already read from the stream.
+func (ts *TunnelServer) handleUpgrade(w http.ResponseWriter, resp *http.Response, streamReader *bufio.Reader, stream net.Conn) error {
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "Upgrade not supported", http.StatusBadGateway)
+		return fmt.Errorf("failed to hijack connection: %w", err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(brw, "HTTP/1.1 %03d %s\r\n", resp.StatusCode, http.StatusText(resp.StatusCode))
+	if err := resp.Header.Write(brw); err != nil {
+		return err
+	}
+	if _, err := brw.WriteString("\r\n"); err != nil {
+		return err
+	}
+	if err := brw.Flush(); err != nil {
+		return err
+	}
+
+	pipe.Join(pipe.NewBufferedConn(conn, brw.Reader), pipe.NewBufferedConn(stream, streamReader))
+	return nil
+}
@@ -0,0 +1,168 @@
+// Package session configures the yamux sessions between clients and the
+// server, and keeps them in check with heartbeats.
+package session
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/yamux"
+
+	"github.com/AYM1607/godig/types"
+)
+
+// Bounds applied to the settings asked for in a handshake.
+const (
+	// MinStreamWindowSize is the initial yamux stream window, windows can't
+	// be smaller.
+	MinStreamWindowSize uint32 = 256 * 1024
+
+	minInterval     = time.Second
+	maxInterval     = 5 * time.Minute
+	minWriteTimeout = time.Second
+	maxWriteTimeout = time.Minute
+)
+
+// Defaults returns the settings used when neither side asks for others.
+func Defaults() types.SessionConfig {
+	return types.SessionConfig{
+		MaxStreamWindowSize:    4 << 20,
+		KeepAliveInterval:      15 * time.Second,
+		ConnectionWriteTimeout: 10 * time.Second,
+		HeartbeatInterval:      10 * time.Second,
+	}
+}
+
+// Negotiate returns the settings for a session that asked for requested.
+// Zero values take the defaults and the rest are kept within sane bounds, the
+// stream window at most maxWindow.
+func Negotiate(requested, defaults types.SessionConfig, maxWindow uint32) types.SessionConfig {
+	cfg := defaults
+	if requested.MaxStreamWindowSize > 0 {
+		cfg.MaxStreamWindowSize = requested.MaxStreamWindowSize
+	}
+	if requested.KeepAliveInterval > 0 {
+		cfg.KeepAliveInterval = requested.KeepAliveInterval
+	}
+	if requested.ConnectionWriteTimeout > 0 {
+		cfg.ConnectionWriteTimeout = requested.ConnectionWriteTimeout
+	}
+	if requested.HeartbeatInterval > 0 {
+		cfg.HeartbeatInterval = requested.HeartbeatInterval
+	}
+
+	cfg.MaxStreamWindowSize = max(min(cfg.MaxStreamWindowSize, maxWindow), MinStreamWindowSize)
+	cfg.KeepAliveInterval = clamp(cfg.KeepAliveInterval, minInterval, maxInterval)
+	cfg.ConnectionWriteTimeout = clamp(cfg.ConnectionWriteTimeout, minWriteTimeout, maxWriteTimeout)
+	cfg.HeartbeatInterval = clamp(cfg.HeartbeatInterval, minInterval, maxInterval)
+	return cfg
+}
+
+func clamp(d, lo, hi time.Duration) time.Duration {
+	return max(min(d, hi), lo)
+}
+
+// Encode returns the handshake form of cfg, nil when nothing is set.
+func Encode(cfg types.SessionConfig) *types.SessionSettings {
+	if cfg == (types.SessionConfig{}) {
+		return nil
+	}
+
+	settings := &types.SessionSettings{MaxStreamWindowSize: cfg.MaxStreamWindowSize}
+	if cfg.KeepAliveInterval > 0 {
+		settings.KeepAliveInterval = cfg.KeepAliveInterval.String()
+	}
+	if cfg.ConnectionWriteTimeout > 0 {
+		settings.ConnectionWriteTimeout = cfg.ConnectionWriteTimeout.String()
+	}
+	if cfg.HeartbeatInterval > 0 {
+		settings.HeartbeatInterval = cfg.HeartbeatInterval.String()
+	}
+	return settings
+}
+
+// Decode parses settings received in a handshake, nil settings are empty.
+func Decode(settings *types.SessionSettings) (types.SessionConfig, error) {
+	if settings == nil {
+		return types.SessionConfig{}, nil
+	}
+
+	cfg := types.SessionConfig{MaxStreamWindowSize: settings.MaxStreamWindowSize}
+	durations := []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"keep-alive interval", settings.KeepAliveInterval, &cfg.KeepAliveInterval},
+		{"connection write timeout", settings.ConnectionWriteTimeout, &cfg.ConnectionWriteTimeout},
+		{"heartbeat interval", settings.HeartbeatInterval, &cfg.HeartbeatInterval},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid %s: %w", d.name, err)
+		}
+		*d.dst = parsed
+	}
+	return cfg, nil
+}
+
+// YamuxConfig returns the yamux configuration for cfg. Zero values keep the
+// yamux defaults.
+func YamuxConfig(cfg types.SessionConfig) *yamux.Config {
+	config := yamux.DefaultConfig()
+	if cfg.MaxStreamWindowSize > 0 {
+		config.MaxStreamWindowSize = max(cfg.MaxStreamWindowSize, MinStreamWindowSize)
+	}
+	if cfg.KeepAliveInterval > 0 {
+		config.KeepAliveInterval = cfg.KeepAliveInterval
+	}
+	if cfg.ConnectionWriteTimeout > 0 {
+		config.ConnectionWriteTimeout = cfg.ConnectionWriteTimeout
+	}
+	return config
+}
+
+// Pinger is a session Heartbeat can check, like a *yamux.Session.
+type Pinger interface {
+	Ping() (time.Duration, error)
+	CloseChan() <-chan struct{}
+	IsClosed() bool
+	Close() error
+}
+
+// Heartbeat pings the other end of s every interval until s is closed or ctx
+// is done. A ping that fails or isn't answered within the connection write
+// timeout closes s, so dead peers are detected long before TCP gives up on
+// them. onBeat, if not nil, is called with the round trip time of every
+// answered ping.
+func Heartbeat(ctx context.Context, s Pinger, interval time.Duration, onBeat func(rtt time.Duration)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.CloseChan():
+			return nil
+		case <-ticker.C:
+		}
+
+		rtt, err := s.Ping()
+		if err != nil {
+			if s.IsClosed() {
+				return nil
+			}
+			s.Close()
+			return fmt.Errorf("heartbeat failed: %w", err)
+		}
+		if onBeat != nil {
+			onBeat(rtt)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package session
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/yamux"
+
+	"github.com/AYM1607/godig/types"
+)
+
+func TestNegotiate(t *testing.T) {
+	defaults := Defaults()
+	const maxWindow = 16 << 20
+
+	tests := []struct {
+		name      string
+		requested types.SessionConfig
+		expected  types.SessionConfig
+	}{
+		{
+			name:      "nothing requested",
+			requested: types.SessionConfig{},
+			expected:  defaults,
+		},
+		{
+			name: "within bounds",
+			requested: types.SessionConfig{
+				MaxStreamWindowSize:    8 << 20,
+				KeepAliveInterval:      30 * time.Second,
+				ConnectionWriteTimeout: 5 * time.Second,
+				HeartbeatInterval:      20 * time.Second,
+			},
+			expected: types.SessionConfig{
+				MaxStreamWindowSize:    8 << 20,
+				KeepAliveInterval:      30 * time.Second,
+				ConnectionWriteTimeout: 5 * time.Second,
+				HeartbeatInterval:      20 * time.Second,
+			},
+		},
+		{
+			name: "partially requested",
+			requested: types.SessionConfig{
+				HeartbeatInterval: 3 * time.Second,
+			},
+			expected: types.SessionConfig{
+				MaxStreamWindowSize:    defaults.MaxStreamWindowSize,
+				KeepAliveInterval:      defaults.KeepAliveInterval,
+				ConnectionWriteTimeout: defaults.ConnectionWriteTimeout,
+				HeartbeatInterval:      3 * time.Second,
+			},
+		},
+		{
+			name: "above bounds",
+			requested: types.SessionConfig{
+				MaxStreamWindowSize:    1 << 30,
+				KeepAliveInterval:      time.Hour,
+				ConnectionWriteTimeout: time.Hour,
+				HeartbeatInterval:      time.Hour,
+			},
+			expected: types.SessionConfig{
+				MaxStreamWindowSize:    maxWindow,
+				KeepAliveInterval:      5 * time.Minute,
+				ConnectionWriteTimeout: time.Minute,
+				HeartbeatInterval:      5 * time.Minute,
+			},
+		},
+		{
+			name: "below bounds",
+			requested: types.SessionConfig{
+				MaxStreamWindowSize:    1024,
+				KeepAliveInterval:      time.Millisecond,
+				ConnectionWriteTimeout: time.Millisecond,
+				HeartbeatInterval:      time.Millisecond,
+			},
+			expected: types.SessionConfig{
+				MaxStreamWindowSize:    MinStreamWindowSize,
+				KeepAliveInterval:      time.Second,
+				ConnectionWriteTimeout: time.Second,
+				HeartbeatInterval:      time.Second,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Negotiate(tt.requested, defaults, maxWindow)
+			if got != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	if settings := Encode(types.SessionConfig{}); settings != nil {
+		t.Errorf("expected nil settings for an empty config, got %+v", settings)
+	}
+
+	cfg := types.SessionConfig{
+		MaxStreamWindowSize: 1 << 20,
+		HeartbeatInterval:   2500 * time.Millisecond,
+	}
+	settings := Encode(cfg)
+	if settings.HeartbeatInterval != "2.5s" || settings.KeepAliveInterval != "" {
+		t.Errorf("unexpected settings %+v", settings)
+	}
+
+	decoded, err := Decode(settings)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decoded != cfg {
+		t.Errorf("expected %+v, got %+v", cfg, decoded)
+	}
+
+	if _, err := Decode(&types.SessionSettings{KeepAliveInterval: "soon"}); err == nil {
+		t.Error("expected an error for an invalid duration")
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	config := yamux.DefaultConfig()
+	config.EnableKeepAlive = false
+	config.ConnectionWriteTimeout = 100 * time.Millisecond
+	config.LogOutput = io.Discard
+
+	t.Run("answered", func(t *testing.T) {
+		clientConn, serverConn := net.Pipe()
+		client, err := yamux.Client(clientConn, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		server, err := yamux.Server(serverConn, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		beats := make(chan time.Duration, 10)
+		done := make(chan error, 1)
+		go func() {
+			done <- Heartbeat(ctx, client, 10*time.Millisecond, func(rtt time.Duration) { beats <- rtt })
+		}()
+
+		select {
+		case <-beats:
+		case <-time.After(time.Second):
+			t.Fatal("expected a heartbeat")
+		}
+
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("expected no error once cancelled, got %v", err)
+		}
+		if client.IsClosed() {
+			t.Error("expected the session to stay open")
+		}
+	})
+
+	t.Run("unanswered", func(t *testing.T) {
+		clientConn, peerConn := net.Pipe()
+		defer peerConn.Close()
+		// The peer reads everything but never answers.
+		go io.Copy(io.Discard, peerConn)
+
+		client, err := yamux.Client(clientConn, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		done := make(chan error, 1)
+		go func() {
+			done <- Heartbeat(context.Background(), client, 10*time.Millisecond, nil)
+		}()
+
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Error("expected an error for an unanswered heartbeat")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected the heartbeat to fail")
+		}
+		if !client.IsClosed() {
+			t.Error("expected the session to be closed")
+		}
+	})
+}
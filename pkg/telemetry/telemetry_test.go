@@ -0,0 +1,73 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetup_ExportsSpans(t *testing.T) {
+	// Stands in for an OTLP collector.
+	var mu sync.Mutex
+	var exports []string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		exports = append(exports, r.Method+" "+r.URL.Path+" "+r.Header.Get("Content-Type"))
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	shutdown, err := Setup(context.Background(), "godig-test", collector.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	var outgoing http.Header
+	handler := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sc := trace.SpanContextFromContext(r.Context())
+		if sc.TraceID().String() != traceID {
+			t.Errorf("expected trace ID %s, got %s", traceID, sc.TraceID())
+		}
+
+		_, span := StartSpan(r.Context(), "child")
+		EndSpan(span, nil)
+
+		outgoing = http.Header{}
+		Inject(r.Context(), outgoing)
+		w.WriteHeader(http.StatusNoContent)
+	}), "test.request")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	traceparent := outgoing.Get("Traceparent")
+	if !strings.HasPrefix(traceparent, "00-"+traceID+"-") || strings.Contains(traceparent, "00f067aa0ba902b7") {
+		t.Errorf("expected a traceparent for a child span, got %q", traceparent)
+	}
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("failed to shut down tracing: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(exports) == 0 {
+		t.Fatal("expected spans to be exported")
+	}
+	if exports[0] != "POST /v1/traces application/x-protobuf" {
+		t.Errorf("unexpected export request %q", exports[0])
+	}
+}
+
+func TestSetup_InvalidEndpoint(t *testing.T) {
+	if _, err := Setup(context.Background(), "godig-test", "localhost:4318"); err == nil {
+		t.Error("expected error but got none")
+	}
+}
@@ -0,0 +1,336 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/AYM1607/godig/pkg/session"
+	"github.com/AYM1607/godig/types"
+)
+
+// QUICProtocol is the ALPN protocol of tunnel connections over QUIC.
+const QUICProtocol = "godig-tunnel"
+
+const (
+	// maxQUICStreams bounds the concurrent streams, which are requests in
+	// flight through a tunnel.
+	maxQUICStreams = 10000
+	// controlHandshakeTimeout bounds the time a client has to open the
+	// control stream once connected.
+	controlHandshakeTimeout = 10 * time.Second
+)
+
+// Frames sent over the control stream once the handshake is done.
+const (
+	pingFrame byte = iota + 1
+	pongFrame
+)
+
+var errPingTimeout = errors.New("ping timed out")
+
+// quicConfig returns the QUIC configuration for cfg. Unlike yamux sessions,
+// QUIC connections are configured before the handshake.
+func quicConfig(cfg types.SessionConfig) *quic.Config {
+	config := &quic.Config{
+		HandshakeIdleTimeout: controlHandshakeTimeout,
+		MaxIncomingStreams:   maxQUICStreams,
+		KeepAlivePeriod:      cfg.KeepAliveInterval,
+	}
+	if cfg.MaxStreamWindowSize > 0 {
+		config.MaxStreamReceiveWindow = uint64(cfg.MaxStreamWindowSize)
+	}
+	return config
+}
+
+// quicTLSConfig returns a copy of tlsConfig that negotiates the tunnel
+// protocol.
+func quicTLSConfig(tlsConfig *tls.Config) *tls.Config {
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.NextProtos = []string{QUICProtocol}
+	return tlsConfig
+}
+
+func dialQUIC(ctx context.Context, addr string, opts DialOptions) (Conn, error) {
+	proxyURL, err := opts.proxyFor(addr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL != nil {
+		return nil, fmt.Errorf("QUIC can't go through the proxy at %s", proxyURL.Redacted())
+	}
+
+	conn, err := quic.DialAddr(ctx, addr, quicTLSConfig(opts.TLSConfig), quicConfig(opts.Session))
+	if err != nil {
+		return nil, err
+	}
+
+	// The handshake goes over a control stream opened by the client, requests
+	// go over streams opened by the server.
+	control, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return nil, err
+	}
+	return newQUICConn(conn, control), nil
+}
+
+// QUICListener accepts tunnel connections over QUIC.
+type QUICListener struct {
+	listener *quic.Listener
+	conns    chan Conn
+	done     chan struct{}
+	err      error
+}
+
+// ListenQUIC listens for tunnel connections over QUIC on the UDP address
+// addr. Connections are configured with cfg, usually the server defaults.
+func ListenQUIC(addr string, tlsConfig *tls.Config, cfg types.SessionConfig) (*QUICListener, error) {
+	listener, err := quic.ListenAddr(addr, quicTLSConfig(tlsConfig), quicConfig(cfg))
+	if err != nil {
+		return nil, err
+	}
+
+	l := &QUICListener{
+		listener: listener,
+		conns:    make(chan Conn),
+		done:     make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l, nil
+}
+
+func (l *QUICListener) acceptLoop() {
+	defer close(l.done)
+	for {
+		conn, err := l.listener.Accept(context.Background())
+		if err != nil {
+			l.err = err
+			return
+		}
+		go l.acceptControl(conn)
+	}
+}
+
+// acceptControl waits for the control stream of conn before handing it out,
+// so a client that never opens it doesn't hold up Accept.
+func (l *QUICListener) acceptControl(conn *quic.Conn) {
+	ctx, cancel := context.WithTimeout(conn.Context(), controlHandshakeTimeout)
+	defer cancel()
+
+	control, err := conn.AcceptStream(ctx)
+	if err != nil {
+		conn.CloseWithError(0, "")
+		return
+	}
+
+	select {
+	case l.conns <- newQUICConn(conn, control):
+	case <-l.done:
+		conn.CloseWithError(0, "")
+	}
+}
+
+// Accept waits for the next tunnel connection.
+func (l *QUICListener) Accept() (Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+// Addr returns the UDP address the listener is bound to.
+func (l *QUICListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+func (l *QUICListener) Close() error {
+	return l.listener.Close()
+}
+
+// quicConn is a QUIC connection, the handshake goes over its control stream.
+type quicConn struct {
+	handshakeCodec
+	conn    *quic.Conn
+	control *quic.Stream
+}
+
+func newQUICConn(conn *quic.Conn, control *quic.Stream) *quicConn {
+	return &quicConn{handshakeCodec: newHandshakeCodec(control), conn: conn, control: control}
+}
+
+func (c *quicConn) SetDeadline(t time.Time) error {
+	return c.control.SetDeadline(t)
+}
+
+func (c *quicConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *quicConn) TLS() *tls.ConnectionState {
+	state := c.conn.ConnectionState().TLS
+	return &state
+}
+
+func (c *quicConn) Close() error {
+	return c.conn.CloseWithError(0, "")
+}
+
+// Session starts a session over the native QUIC streams. Only the heartbeat
+// settings apply, the rest were set up when connecting.
+func (c *quicConn) Session(cfg types.SessionConfig) (Session, error) {
+	timeout := cfg.ConnectionWriteTimeout
+	if timeout <= 0 {
+		timeout = session.Defaults().ConnectionWriteTimeout
+	}
+
+	s := &quicSession{
+		conn:        c.conn,
+		control:     c.control,
+		pingTimeout: timeout,
+		pongs:       make(chan struct{}, 1),
+	}
+	go s.readControl(c.rest())
+	return s, nil
+}
+
+// quicSession carries tunnel streams over the streams of a QUIC connection.
+// Pings go over the control stream.
+type quicSession struct {
+	conn        *quic.Conn
+	control     *quic.Stream
+	pingTimeout time.Duration
+
+	pingMu  sync.Mutex
+	writeMu sync.Mutex
+	pongs   chan struct{}
+}
+
+func (s *quicSession) OpenStream(ctx context.Context) (net.Conn, error) {
+	stream, err := s.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &quicStream{Stream: stream, conn: s.conn}, nil
+}
+
+func (s *quicSession) AcceptStream(ctx context.Context) (net.Conn, error) {
+	stream, err := s.conn.AcceptStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &quicStream{Stream: stream, conn: s.conn}, nil
+}
+
+func (s *quicSession) Ping() (time.Duration, error) {
+	s.pingMu.Lock()
+	defer s.pingMu.Unlock()
+
+	// Drop the late answer to a ping that timed out.
+	select {
+	case <-s.pongs:
+	default:
+	}
+
+	start := time.Now()
+	if err := s.writeControl(pingFrame); err != nil {
+		return 0, err
+	}
+
+	timer := time.NewTimer(s.pingTimeout)
+	defer timer.Stop()
+	select {
+	case <-s.pongs:
+		return time.Since(start), nil
+	case <-timer.C:
+		return 0, errPingTimeout
+	case <-s.conn.Context().Done():
+		return 0, context.Cause(s.conn.Context())
+	}
+}
+
+func (s *quicSession) writeControl(frame byte) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.control.SetWriteDeadline(time.Now().Add(s.pingTimeout))
+	_, err := s.control.Write([]byte{frame})
+	return err
+}
+
+// readControl answers the pings of the other end and delivers its answers
+// until the control stream ends, which ends the session.
+func (s *quicSession) readControl(r io.Reader) {
+	defer s.Close()
+
+	frame := make([]byte, 1)
+	for {
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return
+		}
+		switch frame[0] {
+		case pingFrame:
+			if err := s.writeControl(pongFrame); err != nil {
+				return
+			}
+		case pongFrame:
+			select {
+			case s.pongs <- struct{}{}:
+			default:
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (s *quicSession) CloseChan() <-chan struct{} {
+	return s.conn.Context().Done()
+}
+
+func (s *quicSession) IsClosed() bool {
+	return s.conn.Context().Err() != nil
+}
+
+func (s *quicSession) Close() error {
+	return s.conn.CloseWithError(0, "")
+}
+
+// quicStream is a QUIC stream used as a net.Conn.
+type quicStream struct {
+	*quic.Stream
+	conn *quic.Conn
+}
+
+func (s *quicStream) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+func (s *quicStream) RemoteAddr() net.Addr {
+	return s.conn.RemoteAddr()
+}
+
+// Close closes both directions of the stream, closing a QUIC stream only
+// closes the write direction.
+func (s *quicStream) Close() error {
+	s.Stream.CancelRead(0)
+	return s.Stream.Close()
+}
+
+// CloseWrite closes the write direction of the stream, the other end reads
+// io.EOF.
+func (s *quicStream) CloseWrite() error {
+	return s.Stream.Close()
+}
@@ -0,0 +1,180 @@
+// Package transport carries tunnel connections between clients and the
+// server, over TCP, a WebSocket or QUIC.
+package transport
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/AYM1607/godig/pkg/proxy"
+	"github.com/AYM1607/godig/types"
+)
+
+// Transport names.
+const (
+	TCP       = "tcp"
+	WebSocket = "websocket"
+	QUIC      = "quic"
+
+	// Auto tries TCP first and falls back to a WebSocket on the public HTTPS
+	// port, which gets through networks that only allow HTTPS out.
+	Auto = "auto"
+)
+
+// Session carries the multiplexed streams of a tunnel.
+type Session interface {
+	// OpenStream opens a stream to the other end.
+	OpenStream(ctx context.Context) (net.Conn, error)
+	// AcceptStream waits for a stream opened by the other end.
+	AcceptStream(ctx context.Context) (net.Conn, error)
+	// Ping returns the round trip time to the other end.
+	Ping() (time.Duration, error)
+	// CloseChan is closed once the session is closed.
+	CloseChan() <-chan struct{}
+	IsClosed() bool
+	// Close closes the session and its underlying connection.
+	Close() error
+}
+
+// Conn is a connection between a client and the server. The handshake is
+// exchanged over it before it starts carrying the session.
+type Conn interface {
+	// Encode writes a handshake message.
+	Encode(v any) error
+	// Decode reads a handshake message.
+	Decode(v any) error
+	// SetDeadline bounds the handshake, a zero time clears it.
+	SetDeadline(t time.Time) error
+	RemoteAddr() net.Addr
+	// TLS returns the state of the TLS connection carrying the tunnel, nil
+	// when there is none.
+	TLS() *tls.ConnectionState
+	// Session starts the session once the handshake is done.
+	Session(cfg types.SessionConfig) (Session, error)
+	Close() error
+}
+
+// DialOptions configures the connection to a tunnel server.
+type DialOptions struct {
+	// TLSConfig is used for QUIC and secure WebSocket connections, nil uses
+	// the system roots.
+	TLSConfig *tls.Config
+	// Session holds the requested session settings. QUIC connections are
+	// configured with them when dialing, as they can't change afterwards.
+	Session types.SessionConfig
+	// Proxy returns the proxy TCP and WebSocket connections go through, nil
+	// connects directly. QUIC can't go through a proxy.
+	Proxy proxy.Func
+}
+
+// dialTCP connects to addr, through the proxy for it if there is one.
+func (opts DialOptions) dialTCP(ctx context.Context, addr string) (net.Conn, error) {
+	proxyURL, err := opts.proxyFor(addr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL != nil {
+		return proxy.Dial(ctx, proxyURL, addr)
+	}
+
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+func (opts DialOptions) proxyFor(addr string) (*url.URL, error) {
+	if opts.Proxy == nil {
+		return nil, nil
+	}
+	return opts.Proxy(addr)
+}
+
+// Dial connects to a tunnel server at addr with the named transport. The
+// address is a host and port for TCP and QUIC, and a ws:// or wss:// URL for
+// WebSocket.
+func Dial(ctx context.Context, name, addr string, opts DialOptions) (Conn, error) {
+	switch name {
+	case TCP:
+		conn, err := opts.dialTCP(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		return newMuxConn(conn, false), nil
+	case WebSocket:
+		return dialWebSocket(ctx, addr, opts)
+	case QUIC:
+		return dialQUIC(ctx, addr, opts)
+	default:
+		return nil, fmt.Errorf("unknown transport %q", name)
+	}
+}
+
+// Parse returns the transports in s, a comma separated list in the order
+// they are tried. Auto stands for TCP and then WebSocket.
+func Parse(s string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		expanded := []string{name}
+		switch name {
+		case TCP, WebSocket, QUIC:
+		case Auto:
+			expanded = []string{TCP, WebSocket}
+		default:
+			return nil, fmt.Errorf("unknown transport %q (valid transports: %s, %s, %s, %s)", name, Auto, TCP, WebSocket, QUIC)
+		}
+		for _, name := range expanded {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
+
+// handshakeCodec reads and writes the handshake messages, JSON values on a
+// line of their own as written by a json.Encoder.
+type handshakeCodec struct {
+	w io.Writer
+	r *bufio.Reader
+}
+
+func newHandshakeCodec(rw io.ReadWriter) handshakeCodec {
+	return handshakeCodec{w: rw, r: bufio.NewReader(rw)}
+}
+
+func (c handshakeCodec) Encode(v any) error {
+	return json.NewEncoder(c.w).Encode(v)
+}
+
+func (c handshakeCodec) Decode(v any) error {
+	line, err := c.r.ReadBytes('\n')
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(line, v)
+}
+
+// rest returns a reader for whatever follows the handshake, including the
+// data already buffered.
+func (c handshakeCodec) rest() io.Reader {
+	return c.r
+}
+
+// readerConn is a net.Conn that reads from r.
+type readerConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *readerConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
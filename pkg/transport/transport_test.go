@@ -0,0 +1,267 @@
+package transport
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AYM1607/godig/pkg/session"
+	"github.com/AYM1607/godig/types"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+		wantErr  bool
+	}{
+		{input: "tcp", expected: []string{TCP}},
+		{input: "auto", expected: []string{TCP, WebSocket}},
+		{input: "quic, auto", expected: []string{QUIC, TCP, WebSocket}},
+		{input: "WebSocket,tcp,websocket", expected: []string{WebSocket, TCP}},
+		{input: "udp", wantErr: true},
+		{input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !slices.Equal(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestTransports(t *testing.T) {
+	tests := []struct {
+		name    string
+		connect func(t *testing.T) (client, server Conn)
+	}{
+		{name: TCP, connect: connectTCP},
+		{name: WebSocket, connect: connectWebSocket},
+		{name: QUIC, connect: connectQUIC},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := tt.connect(t)
+			testTunnel(t, client, server)
+		})
+	}
+}
+
+// testTunnel runs a handshake between client and server, then checks their
+// sessions carry streams opened by the server and pings both ways.
+func testTunnel(t *testing.T, client, server Conn) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := server.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("failed to set handshake deadline: %v", err)
+	}
+	var handshake types.HandshakeMessage
+	if err := server.Decode(&handshake); err != nil {
+		t.Fatalf("failed to read handshake: %v", err)
+	}
+	if handshake.TunnelID != "abcde" {
+		t.Errorf("unexpected handshake %+v", handshake)
+	}
+	if err := server.Encode(types.HandshakeResponse{Status: "ok"}); err != nil {
+		t.Fatalf("failed to write handshake response: %v", err)
+	}
+	var response types.HandshakeResponse
+	if err := client.Decode(&response); err != nil {
+		t.Fatalf("failed to read handshake response: %v", err)
+	}
+	server.SetDeadline(time.Time{})
+
+	cfg := session.Defaults()
+	serverSession, err := server.Session(cfg)
+	if err != nil {
+		t.Fatalf("failed to start server session: %v", err)
+	}
+	defer serverSession.Close()
+	clientSession, err := client.Session(cfg)
+	if err != nil {
+		t.Fatalf("failed to start client session: %v", err)
+	}
+	defer clientSession.Close()
+
+	go func() {
+		stream, err := serverSession.OpenStream(ctx)
+		if err != nil {
+			return
+		}
+		defer stream.Close()
+		stream.Write([]byte("ping\n"))
+		io.Copy(stream, stream)
+	}()
+
+	stream, err := clientSession.AcceptStream(ctx)
+	if err != nil {
+		t.Fatalf("failed to accept stream: %v", err)
+	}
+	defer stream.Close()
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(stream, buf); err != nil || string(buf) != "ping\n" {
+		t.Fatalf("expected ping, got %q (%v)", buf, err)
+	}
+	if _, err := stream.Write([]byte("echo\n")); err != nil {
+		t.Fatalf("failed to write to stream: %v", err)
+	}
+	if _, err := io.ReadFull(stream, buf); err != nil || string(buf) != "echo\n" {
+		t.Fatalf("expected echo, got %q (%v)", buf, err)
+	}
+
+	if _, err := clientSession.Ping(); err != nil {
+		t.Errorf("client ping failed: %v", err)
+	}
+	if _, err := serverSession.Ping(); err != nil {
+		t.Errorf("server ping failed: %v", err)
+	}
+
+	serverSession.Close()
+	select {
+	case <-clientSession.CloseChan():
+	case <-ctx.Done():
+		t.Fatal("expected the client session to end with the server one")
+	}
+	if !clientSession.IsClosed() {
+		t.Error("expected the client session to be closed")
+	}
+}
+
+func connectTCP(t *testing.T) (Conn, Conn) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	client := dialWithHandshake(t, TCP, listener.Addr().String(), DialOptions{})
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, NewServerConn(conn)
+}
+
+func connectWebSocket(t *testing.T) (Conn, Conn) {
+	conns := make(chan Conn, 1)
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := AcceptWebSocket(w, r)
+		if err != nil {
+			t.Errorf("failed to accept WebSocket: %v", err)
+			return
+		}
+		conns <- conn
+		<-done
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(done) })
+
+	wsURL := "ws://" + strings.TrimPrefix(srv.URL, "http://") + WebSocketPath
+	client := dialWithHandshake(t, WebSocket, wsURL, DialOptions{})
+	return client, <-conns
+}
+
+func connectQUIC(t *testing.T) (Conn, Conn) {
+	cert, pool := testCertificate(t)
+	listener, err := ListenQUIC("127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}}, session.Defaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	client := dialWithHandshake(t, QUIC, listener.Addr().String(), DialOptions{TLSConfig: &tls.Config{RootCAs: pool}})
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, conn
+}
+
+// dialWithHandshake dials the server and sends the handshake, which QUIC
+// servers wait for before accepting the connection.
+func dialWithHandshake(t *testing.T, name, addr string, opts DialOptions) Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := Dial(ctx, name, addr, opts)
+	if err != nil {
+		t.Fatalf("failed to dial %s: %v", name, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	if err := conn.Encode(types.HandshakeMessage{TunnelID: "abcde"}); err != nil {
+		t.Fatalf("failed to write handshake: %v", err)
+	}
+	return conn
+}
+
+// testCertificate returns a self-signed certificate for 127.0.0.1 and a pool
+// trusting it.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "godig test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool
+}
+
+func TestDial_QUICThroughProxy(t *testing.T) {
+	opts := DialOptions{Proxy: func(string) (*url.URL, error) {
+		return url.Parse("http://proxy:3128")
+	}}
+	if _, err := Dial(context.Background(), QUIC, "127.0.0.1:8080", opts); err == nil {
+		t.Error("expected QUIC to refuse going through a proxy")
+	}
+}
@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/coder/websocket"
+)
+
+// WebSocketPath is where the server accepts tunnel connections over a
+// WebSocket, on its own host.
+const WebSocketPath = "/_godig/tunnel"
+
+// webSocketProtocol is the subprotocol negotiated by tunnel WebSockets.
+const webSocketProtocol = "godig-tunnel"
+
+func dialWebSocket(ctx context.Context, url string, opts DialOptions) (Conn, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = opts.TLSConfig
+	// Proxies are always tunneled through with CONNECT, plain HTTP proxies
+	// may not pass the upgrade on.
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+		return opts.dialTCP(ctx, addr)
+	}
+
+	ws, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{
+		HTTPClient:   &http.Client{Transport: transport},
+		Subprotocols: []string{webSocketProtocol},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ws.Subprotocol() != webSocketProtocol {
+		ws.Close(websocket.StatusProtocolError, "unsupported subprotocol")
+		return nil, fmt.Errorf("server doesn't accept tunnels at %s", url)
+	}
+
+	// The connection outlives ctx, which only bounds the dial.
+	return newMuxConn(websocket.NetConn(context.Background(), ws, websocket.MessageBinary), false), nil
+}
+
+// AcceptWebSocket upgrades r to a WebSocket and returns the server end of the
+// tunnel connection it carries.
+func AcceptWebSocket(w http.ResponseWriter, r *http.Request) (Conn, error) {
+	ws, err := websocket.Accept(w, r, &websocket.AcceptOptions{Subprotocols: []string{webSocketProtocol}})
+	if err != nil {
+		return nil, err
+	}
+	if ws.Subprotocol() != webSocketProtocol {
+		ws.Close(websocket.StatusProtocolError, "unsupported subprotocol")
+		return nil, fmt.Errorf("client didn't ask for the %s subprotocol", webSocketProtocol)
+	}
+
+	conn := newMuxConn(websocket.NetConn(context.Background(), ws, websocket.MessageBinary), true)
+	conn.tlsState = r.TLS
+	return conn, nil
+}
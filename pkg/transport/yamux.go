@@ -0,0 +1,71 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/hashicorp/yamux"
+
+	"github.com/AYM1607/godig/pkg/session"
+	"github.com/AYM1607/godig/types"
+)
+
+// muxConn is a stream connection, TCP or a WebSocket, that carries a yamux
+// session.
+type muxConn struct {
+	net.Conn
+	handshakeCodec
+	server bool
+	// tlsState is the state of the TLS connection below a WebSocket.
+	tlsState *tls.ConnectionState
+}
+
+func newMuxConn(conn net.Conn, server bool) *muxConn {
+	return &muxConn{Conn: conn, handshakeCodec: newHandshakeCodec(conn), server: server}
+}
+
+// NewServerConn returns the server end of a tunnel connection accepted over
+// TCP, optionally over TLS.
+func NewServerConn(conn net.Conn) Conn {
+	return newMuxConn(conn, true)
+}
+
+func (c *muxConn) TLS() *tls.ConnectionState {
+	if c.tlsState != nil {
+		return c.tlsState
+	}
+	if tlsConn, ok := c.Conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		return &state
+	}
+	return nil
+}
+
+func (c *muxConn) Session(cfg types.SessionConfig) (Session, error) {
+	conn := &readerConn{Conn: c.Conn, r: c.rest()}
+
+	var s *yamux.Session
+	var err error
+	if c.server {
+		s, err = yamux.Server(conn, session.YamuxConfig(cfg))
+	} else {
+		s, err = yamux.Client(conn, session.YamuxConfig(cfg))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return yamuxSession{s}, nil
+}
+
+type yamuxSession struct {
+	*yamux.Session
+}
+
+func (s yamuxSession) OpenStream(ctx context.Context) (net.Conn, error) {
+	return s.Session.Open()
+}
+
+func (s yamuxSession) AcceptStream(ctx context.Context) (net.Conn, error) {
+	return s.Session.AcceptStreamWithContext(ctx)
+}
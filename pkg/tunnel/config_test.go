@@ -0,0 +1,46 @@
+package tunnel
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/AYM1607/godig/types"
+)
+
+func TestLoadTunnelConfig_HeaderValues(t *testing.T) {
+	t.Chdir(t.TempDir())
+	content := `tunnel_id: abcde
+headers:
+  request:
+    add:
+      Via: 1.1 godig
+      X-Feature: [search, beta]
+`
+	if err := os.WriteFile(configFileName, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := loadTunnelConfig()
+	if err != nil {
+		t.Fatalf("failed to load tunnel config: %v", err)
+	}
+	expected := map[string]types.HeaderValues{
+		"Via":       {"1.1 godig"},
+		"X-Feature": {"search", "beta"},
+	}
+	if !reflect.DeepEqual(config.Headers.Request.Add, expected) {
+		t.Errorf("expected add rules %v, got %v", expected, config.Headers.Request.Add)
+	}
+
+	if err := saveTunnelConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := loadTunnelConfig()
+	if err != nil {
+		t.Fatalf("failed to reload tunnel config: %v", err)
+	}
+	if !reflect.DeepEqual(saved.Headers.Request.Add, expected) {
+		t.Errorf("expected saved add rules %v, got %v", expected, saved.Headers.Request.Add)
+	}
+}
@@ -0,0 +1,122 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+// errLocalService is returned when accepting the streams of a client that
+// forwards them to a local service.
+var errLocalService = errors.New("tunnel streams are forwarded to the local service")
+
+// Listener returns the listener the tunnel streams are handed to, so Go
+// programs can serve them directly, e.g. with http.Serve. It's only available
+// when the client was created without a local service, and keeps working
+// across reconnections until Run returns.
+func (tc *TunnelClient) Listener() (net.Listener, error) {
+	if tc.handler != nil {
+		return nil, errLocalService
+	}
+	return tc.listener, nil
+}
+
+// Listen opens a tunnel like Open and returns it as a net.Listener, whose
+// Addr is the public URL. No local service is involved, opts.LocalAddr and
+// opts.Config.ServeDir must be empty. Closing the listener closes the tunnel.
+func Listen(ctx context.Context, opts Options) (net.Listener, error) {
+	if opts.LocalAddr != "" || opts.Config.ServeDir != "" {
+		return nil, errors.New("a tunnel listener can't forward to a local service")
+	}
+	return Open(ctx, opts)
+}
+
+// acceptBacklog is the number of streams waiting for Accept before new ones
+// are refused, so a slow Accept never stalls the tunnel session.
+const acceptBacklog = 128
+
+// errBacklogFull is returned when delivering a stream while acceptBacklog
+// streams are already waiting.
+var errBacklogFull = errors.New("too many streams waiting to be accepted")
+
+// streamListener is a net.Listener fed with the streams accepted from the
+// tunnel session, so they can be served by a regular http.Server or by the
+// user of the client.
+type streamListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	url   func() string
+
+	// mu keeps streams from being queued once the listener is closed.
+	mu     sync.Mutex
+	closed bool
+}
+
+// newStreamListener returns a listener whose address is the current url.
+func newStreamListener(url func() string) *streamListener {
+	return &streamListener{
+		conns: make(chan net.Conn, acceptBacklog),
+		done:  make(chan struct{}),
+		url:   url,
+	}
+}
+
+// deliver queues conn for the next Accept call without blocking. It fails,
+// without closing conn, if the listener is closed or the backlog is full.
+func (l *streamListener) deliver(conn net.Conn) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return net.ErrClosed
+	}
+	select {
+	case l.conns <- conn:
+		return nil
+	default:
+		return errBacklogFull
+	}
+}
+
+func (l *streamListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops Accept and closes the streams that were never accepted.
+func (l *streamListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	close(l.done)
+	for {
+		select {
+		case conn := <-l.conns:
+			conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (l *streamListener) Addr() net.Addr {
+	return tunnelAddr(l.url())
+}
+
+// tunnelAddr is the address of a tunnel, its public URL.
+type tunnelAddr string
+
+func (a tunnelAddr) Network() string {
+	return "godig"
+}
+
+func (a tunnelAddr) String() string {
+	return string(a)
+}
@@ -0,0 +1,128 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/AYM1607/godig/types"
+)
+
+func TestListen(t *testing.T) {
+	addr, sessions := testServer(t, "secret")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := Listen(ctx, Options{Server: addr, APIKey: "secret", LocalAddr: "localhost:3000"}); err == nil {
+		t.Error("expected Listen to refuse a local service")
+	}
+
+	listener, err := Listen(ctx, Options{Server: addr, APIKey: "secret", TunnelID: "abcde"})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	if listener.Addr().String() != "https://abcde.godig.xyz" {
+		t.Errorf("unexpected address %q", listener.Addr())
+	}
+
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "served over the tunnel")
+	}))
+	if body := tunnelRequest(t, <-sessions); body != "served over the tunnel" {
+		t.Errorf("unexpected response %q", body)
+	}
+}
+
+func TestTunnelClient_Listener(t *testing.T) {
+	t.Chdir(t.TempDir())
+	addr, sessions := testServer(t, "secret")
+	clientConfig := types.TunnelClientConfig{Logger: log.New(io.Discard, "", 0)}
+
+	proxying, err := NewTunnelClient(addr, "localhost:3000", "secret", clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := proxying.Listener(); err == nil {
+		t.Error("expected no listener for a client with a local service")
+	}
+
+	tc, err := NewTunnelClient(addr, "", "secret", clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tc.Listener()
+	if err != nil {
+		t.Fatalf("failed to get the listener: %v", err)
+	}
+	if listener.Addr().String() != "https://"+tc.TunnelID+".127.0.0.1" {
+		t.Errorf("expected the address guessed from the server host before connecting, got %q", listener.Addr())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		tc.Run(ctx)
+	}()
+	go http.Serve(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "served over the tunnel")
+	}))
+
+	if body := tunnelRequest(t, <-sessions); body != "served over the tunnel" {
+		t.Errorf("unexpected response %q", body)
+	}
+	if listener.Addr().String() != "https://"+tc.TunnelID+".godig.xyz" {
+		t.Errorf("expected the address reported by the server, got %q", listener.Addr())
+	}
+
+	cancel()
+	<-stopped
+	if _, err := listener.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected Accept to fail once the client stopped, got %v", err)
+	}
+}
+
+func TestStreamListener_Backlog(t *testing.T) {
+	listener := newStreamListener(func() string { return "https://abcde.godig.xyz" })
+
+	// Nothing accepts the streams, delivering them must not block.
+	var peers []net.Conn
+	for range acceptBacklog {
+		conn, peer := net.Pipe()
+		peers = append(peers, peer)
+		if err := listener.deliver(conn); err != nil {
+			t.Fatalf("failed to deliver a stream within the backlog: %v", err)
+		}
+	}
+	conn, _ := net.Pipe()
+	if err := listener.deliver(conn); !errors.Is(err, errBacklogFull) {
+		t.Errorf("expected a full backlog, got %v", err)
+	}
+
+	accepted, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %v", err)
+	}
+	defer accepted.Close()
+	if err := listener.deliver(conn); err != nil {
+		t.Errorf("expected Accept to make room in the backlog, got %v", err)
+	}
+
+	// The streams never accepted are closed with the listener.
+	listener.Close()
+	peers[len(peers)-1].SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := peers[len(peers)-1].Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected a waiting stream to be closed, got %v", err)
+	}
+	if err := listener.deliver(conn); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected delivering to a closed listener to fail, got %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"sync"
+
+	"github.com/AYM1607/godig/types"
+)
+
+// Options configures a tunnel opened with Open. Unlike NewTunnelClient, Open
+// never reads or writes godig-tunnel.yaml and logs nothing unless asked to.
+type Options struct {
+	// Server is the address of the tunnel server, as host:port.
+	Server string
+	// APIKey authenticates the tunnel with the server.
+	APIKey string
+	// TunnelID is the subdomain the tunnel is served on, a random one is
+	// generated when empty.
+	TunnelID string
+	// Bearer is the token requests to the tunnel must carry. A random one is
+	// generated when empty, unless Config.DisableAuth is set.
+	Bearer string
+	// LocalAddr is the local service requests are forwarded to, in any form
+	// accepted by the command line client. When it's empty and
+	// Config.ServeDir isn't set either, the connections opened through the
+	// tunnel are returned by Tunnel.Accept instead.
+	LocalAddr string
+	// Config holds the rest of the client settings. PersistConfig is ignored,
+	// logs are discarded when Config.Logger or Config.AccessLog are nil.
+	Config types.TunnelClientConfig
+}
+
+// Tunnel is a tunnel opened with Open. It reconnects on its own until closed.
+//
+// When no local service is configured, Tunnel is a net.Listener returning the
+// connections opened through the tunnel, each carrying the HTTP requests of a
+// public client, so the tunnel can be served with http.Serve.
+type Tunnel struct {
+	client    *TunnelClient
+	cancel    context.CancelFunc
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Open connects a tunnel to the server and returns once the server accepted
+// it, or with the error of the first attempt. ctx only bounds the connection,
+// the tunnel stays open until Close is called.
+func Open(ctx context.Context, opts Options) (*Tunnel, error) {
+	if opts.Server == "" {
+		return nil, errors.New("tunnel server address is required")
+	}
+
+	tunnelConfig, err := newTunnelConfig(opts.TunnelID, opts.Config.DisableAuth)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Bearer != "" && !opts.Config.DisableAuth {
+		tunnelConfig.Bearer = &opts.Bearer
+	}
+
+	clientConfig := opts.Config
+	clientConfig.PersistConfig = false
+	if clientConfig.Logger == nil {
+		clientConfig.Logger = log.New(io.Discard, "", 0)
+	}
+	if clientConfig.AccessLog == nil {
+		clientConfig.AccessLog = slog.DiscardHandler
+	}
+
+	client, err := newTunnelClient(opts.Server, opts.LocalAddr, opts.APIKey, *tunnelConfig, clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := make(chan error, 1)
+	client.onAttempt = func(err error) {
+		select {
+		case attempts <- err:
+		default:
+		}
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	t := &Tunnel{
+		client: client,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(t.done)
+		client.Run(runCtx)
+	}()
+
+	select {
+	case err = <-attempts:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// URL returns the public URL of the tunnel.
+func (t *Tunnel) URL() string {
+	return t.client.URL()
+}
+
+// ID returns the tunnel ID, its subdomain.
+func (t *Tunnel) ID() string {
+	return t.client.TunnelID
+}
+
+// Bearer returns the token requests to the tunnel must carry, empty when
+// authentication is disabled.
+func (t *Tunnel) Bearer() string {
+	if t.client.Bearer == nil {
+		return ""
+	}
+	return *t.client.Bearer
+}
+
+// Status returns the current state of the tunnel.
+func (t *Tunnel) Status() types.TunnelStatus {
+	return t.client.Status()
+}
+
+// Accept waits for the next connection opened through the tunnel. It fails
+// for tunnels forwarding to a local service, which are served by the client.
+func (t *Tunnel) Accept() (net.Conn, error) {
+	listener, err := t.client.Listener()
+	if err != nil {
+		return nil, err
+	}
+	return listener.Accept()
+}
+
+// Addr returns the address of the tunnel, its public URL.
+func (t *Tunnel) Addr() net.Addr {
+	return t.client.listener.Addr()
+}
+
+// Close disconnects the tunnel and waits for the client to stop. Requests
+// in flight are cut.
+func (t *Tunnel) Close() error {
+	t.closeOnce.Do(func() {
+		// Closing the listener first releases a stream waiting for Accept.
+		t.client.listener.Close()
+		t.cancel()
+		<-t.done
+	})
+	return nil
+}
@@ -0,0 +1,172 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/AYM1607/godig/pkg/session"
+	"github.com/AYM1607/godig/pkg/transport"
+	"github.com/AYM1607/godig/types"
+)
+
+func TestOpen(t *testing.T) {
+	t.Chdir(t.TempDir())
+	addr, sessions := testServer(t, "secret")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tun, err := Open(ctx, Options{Server: addr, APIKey: "secret", TunnelID: "abcde"})
+	if err != nil {
+		t.Fatalf("failed to open tunnel: %v", err)
+	}
+	defer tun.Close()
+
+	if tun.URL() != "https://abcde.godig.xyz" || tun.Addr().String() != tun.URL() {
+		t.Errorf("unexpected URL %q and address %q", tun.URL(), tun.Addr())
+	}
+	if tun.Bearer() == "" {
+		t.Error("expected a generated bearer")
+	}
+	if state := tun.Status().State; state != StateConnected {
+		t.Errorf("expected state %s, got %s", StateConnected, state)
+	}
+
+	go http.Serve(tun, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello from "+r.Host)
+	}))
+
+	if body := tunnelRequest(t, <-sessions); body != "hello from abcde.godig.xyz" {
+		t.Errorf("unexpected response %q", body)
+	}
+
+	tun.Close()
+	if _, err := tun.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected Accept to fail after Close, got %v", err)
+	}
+	if _, err := os.Stat(configFileName); !os.IsNotExist(err) {
+		t.Errorf("expected no tunnel config file, got %v", err)
+	}
+}
+
+func TestOpen_LocalAddr(t *testing.T) {
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello from local")
+	}))
+	defer local.Close()
+	addr, sessions := testServer(t, "secret")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tun, err := Open(ctx, Options{
+		Server:    addr,
+		APIKey:    "secret",
+		Bearer:    "token",
+		LocalAddr: local.URL,
+	})
+	if err != nil {
+		t.Fatalf("failed to open tunnel: %v", err)
+	}
+	defer tun.Close()
+
+	if tun.ID() == "" || tun.Bearer() != "token" {
+		t.Errorf("unexpected tunnel ID %q and bearer %q", tun.ID(), tun.Bearer())
+	}
+	if body := tunnelRequest(t, <-sessions); body != "hello from local" {
+		t.Errorf("unexpected response %q", body)
+	}
+	if _, err := tun.Accept(); err == nil {
+		t.Error("expected Accept to fail with a local service")
+	}
+}
+
+func TestOpen_Rejected(t *testing.T) {
+	addr, _ := testServer(t, "secret")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := Open(ctx, Options{Server: addr, APIKey: "wrong"}); err == nil {
+		t.Fatal("expected Open to fail with a wrong API key")
+	}
+}
+
+// testServer runs a minimal tunnel server and returns its address, along with
+// the sessions of the tunnels it accepts.
+func testServer(t *testing.T, apiKey string) (string, <-chan transport.Session) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	sessions := make(chan transport.Session, 1)
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn := transport.NewServerConn(c)
+
+			var handshake types.HandshakeMessage
+			if err := conn.Decode(&handshake); err != nil || handshake.APIKey != apiKey {
+				conn.Close()
+				continue
+			}
+			cfg := session.Defaults()
+			muxSession, err := conn.Session(cfg)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			conn.Encode(types.HandshakeResponse{
+				Status:  "ok",
+				URL:     "https://" + handshake.TunnelID + ".godig.xyz",
+				Session: session.Encode(cfg),
+			})
+			sessions <- muxSession
+		}
+	}()
+	return listener.Addr().String(), sessions
+}
+
+// tunnelRequest sends a request through a stream of s and returns the
+// response body.
+func tunnelRequest(t *testing.T, s transport.Session) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := s.OpenStream(ctx)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer stream.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://abcde.godig.xyz/", nil)
+	if err := req.Write(stream); err != nil {
+		t.Fatalf("failed to write request: %v", err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(stream), req)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response body: %v", err)
+	}
+	return string(body)
+}
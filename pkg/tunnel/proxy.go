@@ -0,0 +1,159 @@
+package tunnel
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+
+	"github.com/AYM1607/godig/pkg/accesslog"
+	"github.com/AYM1607/godig/pkg/headers"
+	"github.com/AYM1607/godig/pkg/pipe"
+	"github.com/AYM1607/godig/pkg/telemetry"
+)
+
+// forwardedHeaders are set by the tunnel server and must reach the local
+// service untouched.
+var forwardedHeaders = []string{
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Forwarded-Proto",
+}
+
+// localProxy forwards the requests read from tunnel streams to the local
+// service.
+type localProxy struct {
+	upstream *upstream
+	proxy    *httputil.ReverseProxy
+	logger   *log.Logger
+}
+
+func newLocalProxy(up *upstream, logger *log.Logger) *localProxy {
+	return &localProxy{
+		upstream: up,
+		logger:   logger,
+		proxy: &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(up.target)
+				// Keep the public host and the proxy headers added by the
+				// server, the reverse proxy drops them by default.
+				pr.Out.Host = pr.In.Host
+				for _, key := range forwardedHeaders {
+					if values, ok := pr.In.Header[key]; ok {
+						pr.Out.Header[key] = values
+					}
+				}
+				// The local service continues the trace from the tunnel span.
+				telemetry.Inject(pr.In.Context(), pr.Out.Header)
+			},
+			Transport: up.newTransport(),
+			// Trailers can only follow a chunked body. HTTP/2 services send
+			// the length of short replies, like gRPC unary ones, which would
+			// drop their trailers.
+			ModifyResponse: func(resp *http.Response) error {
+				if len(resp.Trailer) > 0 {
+					resp.Header.Del("Content-Length")
+					resp.ContentLength = -1
+				}
+				return nil
+			},
+			// Write data as soon as it arrives, the server decides how to
+			// buffer responses.
+			FlushInterval: -1,
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				logger.Printf("Failed to reach local service for %s: %v", accesslog.RequestID(r.Context()), err)
+				w.WriteHeader(http.StatusBadGateway)
+			},
+		},
+	}
+}
+
+func (p *localProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = r.WithContext(withPublicConn(r.Context(), publicConnAddrs(r)))
+
+	if isUpgradeRequest(r) {
+		p.serveUpgrade(w, r)
+		return
+	}
+
+	// Request bodies may keep streaming while the response is written, as in
+	// gRPC bidi streams.
+	if err := http.NewResponseController(w).EnableFullDuplex(); err != nil {
+		p.logger.Printf("Failed to enable full duplex: %v", err)
+	}
+
+	p.proxy.ServeHTTP(w, r)
+}
+
+// serveUpgrade passes upgraded connections, like WebSockets, through to the
+// local service as raw bytes.
+func (p *localProxy) serveUpgrade(w http.ResponseWriter, r *http.Request) {
+	localConn, err := p.upstream.dial(r.Context())
+	if err != nil {
+		p.logger.Printf("Failed to connect to local service: %v", err)
+		http.Error(w, "Failed to connect to local service", http.StatusBadGateway)
+		return
+	}
+	defer localConn.Close()
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		p.logger.Printf("Failed to hijack tunnel stream: %v", err)
+		http.Error(w, "Upgrade not supported", http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	// Forward the request to local service
+	telemetry.Inject(r.Context(), r.Header)
+	if err := r.Write(localConn); err != nil {
+		p.logger.Printf("Failed to write request to local service: %v", err)
+		return
+	}
+
+	// Data sent right after the request may already be buffered.
+	pipe.Join(pipe.NewBufferedConn(conn, brw.Reader), localConn)
+}
+
+// isUpgradeRequest reports whether r asks to switch protocols.
+func isUpgradeRequest(r *http.Request) bool {
+	return headers.UpgradeType(r.Header) != ""
+}
+
+// publicConn holds the addresses of the public connection a request came
+// from, nil when unknown.
+type publicConn struct {
+	src net.Addr
+	dst net.Addr
+}
+
+type publicConnKey struct{}
+
+func withPublicConn(ctx context.Context, conn publicConn) context.Context {
+	return context.WithValue(ctx, publicConnKey{}, conn)
+}
+
+func publicConnFromContext(ctx context.Context) publicConn {
+	conn, _ := ctx.Value(publicConnKey{}).(publicConn)
+	return conn
+}
+
+// publicConnAddrs returns the public connection addresses reported by the
+// tunnel server, and removes their headers from r. Older servers only report
+// the client IP.
+func publicConnAddrs(r *http.Request) publicConn {
+	var conn publicConn
+	if addr, err := net.ResolveTCPAddr("tcp", r.Header.Get(headers.ClientAddrHeader)); err == nil {
+		conn.src = addr
+	} else if ip := net.ParseIP(r.Header.Get("X-Real-IP")); ip != nil {
+		conn.src = &net.TCPAddr{IP: ip}
+	}
+	if addr, err := net.ResolveTCPAddr("tcp", r.Header.Get(headers.PublicAddrHeader)); err == nil {
+		conn.dst = addr
+	}
+	r.Header.Del(headers.ClientAddrHeader)
+	r.Header.Del(headers.PublicAddrHeader)
+	return conn
+}
@@ -0,0 +1,218 @@
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AYM1607/godig/pkg/headers"
+	"github.com/AYM1607/godig/types"
+)
+
+// h2cServer runs a local service that only speaks HTTP/2 over cleartext, like
+// gRPC servers, and returns its address.
+func h2cServer(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ProtoMajor != 2 {
+				t.Errorf("expected an HTTP/2 request, got %s", r.Proto)
+			}
+			handler(w, r)
+		}),
+		Protocols: &protocols,
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().String()
+}
+
+// openH2CStream returns a tunnel stream to the h2c local service at
+// localAddr, requests can be written to it as the server would.
+func openH2CStream(t *testing.T, localAddr string) net.Conn {
+	t.Helper()
+	handler, err := newHandler(localAddr, types.TunnelClientConfig{H2C: true}, types.HeaderConfig{}, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener := newStreamListener(func() string { return "https://abcde.godig.xyz" })
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	tc := &TunnelClient{listener: listener}
+	stream, tunnelSide := net.Pipe()
+	go tc.handleStream(tunnelSide)
+	t.Cleanup(func() { stream.Close() })
+	return stream
+}
+
+func TestLocalProxy_H2CTrailers(t *testing.T) {
+	localAddr := h2cServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if te := r.Header.Get("Te"); te != "trailers" {
+			t.Errorf("expected TE: trailers to reach the local service, got %q", te)
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		io.WriteString(w, "reply to "+string(body))
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "OK")
+	})
+	stream := openH2CStream(t, localAddr)
+
+	req, _ := http.NewRequest(http.MethodPost, "http://abcde.godig.xyz/echo.Echo/Say", nil)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	req.Body = io.NopCloser(strings.NewReader("hello"))
+	req.ContentLength = 5
+	if err := req.Write(stream); err != nil {
+		t.Fatalf("failed to write request: %v", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(stream), req)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+
+	if string(body) != "reply to hello" {
+		t.Errorf("unexpected body %q", body)
+	}
+	if resp.Trailer.Get("Grpc-Status") != "0" || resp.Trailer.Get("Grpc-Message") != "OK" {
+		t.Errorf("expected the gRPC trailers, got %v", resp.Trailer)
+	}
+}
+
+func TestLocalProxy_H2CStream(t *testing.T) {
+	// Echoes every line as soon as it arrives, like a bidirectional gRPC
+	// stream.
+	localAddr := h2cServer(t, func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		rc.EnableFullDuplex()
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.WriteHeader(http.StatusOK)
+		rc.Flush()
+
+		lines := bufio.NewScanner(r.Body)
+		for lines.Scan() {
+			fmt.Fprintf(w, "echo %s\n", lines.Text())
+			rc.Flush()
+		}
+		w.Header().Set("Grpc-Status", "0")
+	})
+	stream := openH2CStream(t, localAddr)
+
+	fmt.Fprint(stream, "POST /echo.Echo/Stream HTTP/1.1\r\nHost: abcde.godig.xyz\r\nContent-Type: application/grpc\r\nTe: trailers\r\nTransfer-Encoding: chunked\r\n\r\n")
+	body := httputil.NewChunkedWriter(stream)
+
+	req, _ := http.NewRequest(http.MethodPost, "http://abcde.godig.xyz/echo.Echo/Stream", nil)
+	resp, err := http.ReadResponse(bufio.NewReader(stream), req)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	defer resp.Body.Close()
+	replies := bufio.NewReader(resp.Body)
+
+	// Each reply must arrive before the next message is sent.
+	for _, message := range []string{"one", "two"} {
+		fmt.Fprintf(body, "%s\n", message)
+		reply, err := replies.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read the reply to %q: %v", message, err)
+		}
+		if reply != "echo "+message+"\n" {
+			t.Errorf("unexpected reply %q", reply)
+		}
+	}
+
+	body.Close()
+	io.WriteString(stream, "\r\n")
+	if rest, err := io.ReadAll(replies); err != nil || len(rest) != 0 {
+		t.Errorf("expected the stream to end, got %q and %v", rest, err)
+	}
+	if resp.Trailer.Get("Grpc-Status") != "0" {
+		t.Errorf("expected the gRPC status trailer, got %v", resp.Trailer)
+	}
+}
+
+func TestLocalProxy_ProxyProtocol(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	// Reads the PROXY protocol header of every connection before serving it.
+	proxyHeaders := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				proxyHeaders <- strings.TrimSuffix(line, "\r\n")
+				req, err := http.ReadRequest(r)
+				if err != nil {
+					t.Errorf("failed to read request: %v", err)
+					return
+				}
+				if req.Header.Get(headers.ClientAddrHeader) != "" || req.Header.Get(headers.PublicAddrHeader) != "" {
+					t.Errorf("expected the connection address headers to be removed, got %v", req.Header)
+				}
+				io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok")
+			}()
+		}
+	}()
+
+	up, err := newUpstream(listener.Addr().String(), types.TunnelClientConfig{ProxyProtocol: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := newLocalProxy(up, log.New(io.Discard, "", 0))
+
+	// Every request gets its own connection and header.
+	for range 2 {
+		req := httptest.NewRequest(http.MethodGet, "http://abcde.godig.xyz/", nil)
+		req.Header.Set(headers.ClientAddrHeader, "203.0.113.7:51234")
+		req.Header.Set(headers.PublicAddrHeader, "198.51.100.1:443")
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Body.String() != "ok" {
+			t.Fatalf("unexpected response %d %q", w.Code, w.Body)
+		}
+
+		select {
+		case header := <-proxyHeaders:
+			if header != "PROXY TCP4 203.0.113.7 198.51.100.1 51234 443" {
+				t.Errorf("unexpected PROXY protocol header %q", header)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected a new connection with a PROXY protocol header")
+		}
+	}
+}
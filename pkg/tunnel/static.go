@@ -0,0 +1,116 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// staticHandler serves files from a local directory directly through the
+// tunnel, without a local HTTP server. Content types and range requests are
+// handled by http.FileServer.
+type staticHandler struct {
+	fsys       http.FileSystem
+	fileServer http.Handler
+	spa        bool
+}
+
+// newStaticHandler returns a handler for dir. Directory listings are only
+// served when listing is true. With spa, unknown paths are answered with the
+// root index.html so client side routers can handle them.
+func newStaticHandler(dir string, listing, spa bool) (*staticHandler, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open directory to serve: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	fsys := &staticFS{
+		fs:      http.Dir(dir),
+		listing: listing,
+	}
+
+	return &staticHandler{
+		fsys:       fsys,
+		fileServer: http.FileServer(fsys),
+		spa:        spa,
+	}, nil
+}
+
+func (h *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.spa && h.isFallback(r) {
+		r = r.Clone(r.Context())
+		r.URL.Path = "/"
+		r.URL.RawPath = ""
+	}
+
+	h.fileServer.ServeHTTP(w, r)
+}
+
+// isFallback reports whether r should be answered with the SPA index. Only
+// missing paths that look like routes, not files, fall back.
+func (h *staticHandler) isFallback(r *http.Request) bool {
+	name := path.Clean("/" + r.URL.Path)
+	if path.Ext(name) != "" {
+		return false
+	}
+
+	f, err := h.fsys.Open(name)
+	if err != nil {
+		return errors.Is(err, fs.ErrNotExist)
+	}
+	f.Close()
+	return false
+}
+
+// staticFS hides dotfiles, which often hold secrets such as .env or .git, and
+// directories without an index.html unless listings are enabled.
+type staticFS struct {
+	fs      http.FileSystem
+	listing bool
+}
+
+func (s *staticFS) Open(name string) (http.File, error) {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") && part != "." && part != ".." {
+			return nil, fs.ErrNotExist
+		}
+	}
+
+	f, err := s.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.listing {
+		return f, nil
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		index, err := s.fs.Open(path.Join(name, "index.html"))
+		if err != nil {
+			f.Close()
+			return nil, fs.ErrNotExist
+		}
+		index.Close()
+	}
+
+	return f, nil
+}
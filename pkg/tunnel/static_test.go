@@ -0,0 +1,131 @@
+package tunnel
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestStaticDir(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"index.html":     "<html>index</html>",
+		"app.js":         "console.log('app')",
+		".env":           "SECRET=1",
+		"assets/img.txt": "0123456789",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	return dir
+}
+
+func TestStaticHandler(t *testing.T) {
+	dir := newTestStaticDir(t)
+
+	tests := []struct {
+		name           string
+		listing        bool
+		spa            bool
+		path           string
+		header         http.Header
+		expectedStatus int
+		expectedBody   string
+		expectedType   string
+	}{
+		{
+			name:           "file with content type",
+			path:           "/app.js",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "console.log('app')",
+			expectedType:   "text/javascript; charset=utf-8",
+		},
+		{
+			name:           "dotfiles are hidden",
+			path:           "/.env",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "listing disabled",
+			path:           "/assets/",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "listing enabled",
+			listing:        true,
+			path:           "/assets/",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "img.txt",
+		},
+		{
+			name:           "range request",
+			path:           "/assets/img.txt",
+			header:         http.Header{"Range": {"bytes=2-4"}},
+			expectedStatus: http.StatusPartialContent,
+			expectedBody:   "234",
+		},
+		{
+			name:           "missing route without spa",
+			path:           "/dashboard/settings",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "spa fallback",
+			spa:            true,
+			path:           "/dashboard/settings",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "<html>index</html>",
+		},
+		{
+			name:           "spa keeps missing files as not found",
+			spa:            true,
+			path:           "/missing.js",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, err := newStaticHandler(dir, tt.listing, tt.spa)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			if tt.expectedBody != "" && !strings.Contains(rec.Body.String(), tt.expectedBody) {
+				t.Errorf("expected body to contain %q, got %q", tt.expectedBody, rec.Body.String())
+			}
+			if tt.expectedType != "" && rec.Header().Get("Content-Type") != tt.expectedType {
+				t.Errorf("expected content type %q, got %q", tt.expectedType, rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestNewStaticHandler_NotADirectory(t *testing.T) {
+	dir := newTestStaticDir(t)
+
+	if _, err := newStaticHandler(filepath.Join(dir, "app.js"), false, false); err == nil {
+		t.Error("expected error but got none")
+	}
+}
@@ -0,0 +1,141 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/AYM1607/godig/types"
+)
+
+// Connection states reported in types.TunnelStatus.
+const (
+	StateConnecting   = "connecting"
+	StateConnected    = "connected"
+	StateDisconnected = "disconnected"
+	StateStopped      = "stopped"
+)
+
+// tunnelState tracks the connection of a tunnel client for status reports.
+type tunnelState struct {
+	mu          sync.Mutex
+	state       string
+	startedAt   time.Time
+	connectedAt time.Time
+	connections int
+	transport   string
+	url         string
+	lastError   error
+	lastErrorAt time.Time
+
+	lastHeartbeat time.Time
+	heartbeatRTT  time.Duration
+}
+
+func (s *tunnelState) start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.startedAt = time.Now()
+}
+
+func (s *tunnelState) connecting() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = StateConnecting
+}
+
+func (s *tunnelState) connected(transport, url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = StateConnected
+	s.transport = transport
+	s.url = url
+	s.connectedAt = time.Now()
+	s.connections++
+	s.lastHeartbeat = time.Time{}
+	s.heartbeatRTT = 0
+}
+
+// heartbeat records a heartbeat answered by the server.
+func (s *tunnelState) heartbeat(rtt time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastHeartbeat = time.Now()
+	s.heartbeatRTT = rtt
+}
+
+// failed records err and marks the tunnel as disconnected while it retries.
+func (s *tunnelState) failed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = StateDisconnected
+	s.lastError = err
+	s.lastErrorAt = time.Now()
+}
+
+func (s *tunnelState) stopped() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = StateStopped
+}
+
+// Status returns the current state of the tunnel.
+func (tc *TunnelClient) Status() types.TunnelStatus {
+	s := &tc.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := types.TunnelStatus{
+		State:     s.state,
+		TunnelID:  tc.TunnelID,
+		URL:       tc.publicURL(s.url),
+		Server:    tc.serverAddr,
+		StartedAt: s.startedAt,
+	}
+	if status.State == "" {
+		status.State = StateStopped
+	}
+	if !s.startedAt.IsZero() {
+		status.Uptime = time.Since(s.startedAt).Round(time.Second).String()
+	}
+	if s.state == StateConnected {
+		connectedAt := s.connectedAt
+		status.ConnectedAt = &connectedAt
+		status.Transport = s.transport
+		if !s.lastHeartbeat.IsZero() {
+			lastHeartbeat := s.lastHeartbeat
+			status.LastHeartbeat = &lastHeartbeat
+			status.HeartbeatRTT = s.heartbeatRTT.String()
+		}
+	}
+	if s.connections > 1 {
+		status.Reconnects = s.connections - 1
+	}
+	if s.lastError != nil {
+		lastErrorAt := s.lastErrorAt
+		status.LastError = s.lastError.Error()
+		status.LastErrorAt = &lastErrorAt
+	}
+	return status
+}
+
+// StatusHandler serves the tunnel status as JSON on /status, and a probe on
+// /healthz that fails while the tunnel is not connected.
+func (tc *TunnelClient) StatusHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tc.Status())
+	})
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		state := tc.Status().State
+		if state != StateConnected {
+			http.Error(w, state, http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(state + "\n"))
+	})
+	return mux
+}
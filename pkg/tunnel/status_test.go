@@ -0,0 +1,92 @@
+package tunnel
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AYM1607/godig/types"
+)
+
+func TestTunnelClient_Status(t *testing.T) {
+	tc := &TunnelClient{TunnelID: "abcde", serverAddr: "godig.xyz:8080"}
+
+	if status := tc.Status(); status.State != StateStopped || status.URL != "https://abcde.godig.xyz" {
+		t.Errorf("expected state %s and the URL guessed from the server host before running, got %+v", StateStopped, status)
+	}
+
+	tc.status.start()
+	tc.status.connecting()
+	tc.status.failed(errors.New("connection refused"))
+	tc.status.connecting()
+	tc.status.connected("tcp", "https://abcde.tunnels.example")
+	tc.status.failed(errors.New("connection lost: EOF"))
+	tc.status.connecting()
+	tc.status.connected("tcp", "https://abcde.tunnels.example")
+
+	status := tc.Status()
+	if status.State != StateConnected {
+		t.Errorf("expected state %s, got %s", StateConnected, status.State)
+	}
+	if status.URL != "https://abcde.tunnels.example" {
+		t.Errorf("unexpected URL %q", status.URL)
+	}
+	if status.Reconnects != 1 {
+		t.Errorf("expected 1 reconnect, got %d", status.Reconnects)
+	}
+	if status.LastError != "connection lost: EOF" || status.LastErrorAt == nil {
+		t.Errorf("unexpected last error %q at %v", status.LastError, status.LastErrorAt)
+	}
+	if status.ConnectedAt == nil || status.Uptime == "" {
+		t.Errorf("expected connection time and uptime, got %v and %q", status.ConnectedAt, status.Uptime)
+	}
+	if status.LastHeartbeat != nil {
+		t.Errorf("expected no heartbeat yet, got %v", status.LastHeartbeat)
+	}
+
+	tc.status.heartbeat(25 * time.Millisecond)
+
+	status = tc.Status()
+	if status.LastHeartbeat == nil || status.HeartbeatRTT != "25ms" {
+		t.Errorf("unexpected heartbeat %v with rtt %q", status.LastHeartbeat, status.HeartbeatRTT)
+	}
+
+	tc.status.failed(errors.New("heartbeat failed: i/o deadline reached"))
+	if status := tc.Status(); status.LastHeartbeat != nil {
+		t.Errorf("expected no heartbeat while disconnected, got %v", status.LastHeartbeat)
+	}
+}
+
+func TestTunnelClient_StatusHandler(t *testing.T) {
+	tc := &TunnelClient{TunnelID: "abcde", serverAddr: "godig.xyz:8080"}
+	tc.status.start()
+	tc.status.connecting()
+	handler := tc.StatusHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status 503 while connecting, got %d", rec.Code)
+	}
+
+	tc.status.connected("tcp", "https://abcde.tunnels.example")
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200 once connected, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var status types.TunnelStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode status: %v", err)
+	}
+	if status.State != StateConnected || status.TunnelID != "abcde" || status.URL != "https://abcde.tunnels.example" {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
@@ -0,0 +1,91 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/AYM1607/godig/pkg/transport"
+	"github.com/AYM1607/godig/types"
+)
+
+// dialTimeout bounds every connection attempt, so a transport blocked by the
+// network falls back to the next one in time.
+const dialTimeout = 10 * time.Second
+
+// newServerTLSConfig returns the TLS configuration used to reach the server
+// over QUIC or a secure WebSocket.
+func newServerTLSConfig(clientConfig types.TunnelClientConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if clientConfig.ServerCAFile != "" {
+		pool, err := loadCAPool(clientConfig.ServerCAFile, "server CA file")
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// transportAddr returns the address the server is reached at with the named
+// transport.
+func (tc *TunnelClient) transportAddr(name string) string {
+	switch name {
+	case transport.WebSocket:
+		if tc.config.WebSocketURL != "" {
+			return tc.config.WebSocketURL
+		}
+		host, _, err := net.SplitHostPort(tc.serverAddr)
+		if err != nil {
+			host = tc.serverAddr
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		u := url.URL{Scheme: "wss", Host: host, Path: transport.WebSocketPath}
+		return u.String()
+	case transport.QUIC:
+		if tc.config.QUICAddr != "" {
+			return tc.config.QUICAddr
+		}
+	}
+	return tc.serverAddr
+}
+
+// dial connects to the server with the first transport that works, in the
+// configured order, and returns the connection along with the transport name.
+func (tc *TunnelClient) dial(ctx context.Context) (transport.Conn, string, error) {
+	transports := tc.config.Transports
+	if len(transports) == 0 {
+		transports = []string{transport.TCP}
+	}
+
+	opts := transport.DialOptions{
+		TLSConfig: tc.serverTLS,
+		Session:   tc.config.Session,
+		Proxy:     tc.proxy,
+	}
+
+	var errs []error
+	for _, name := range transports {
+		addr := tc.transportAddr(name)
+
+		dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+		conn, err := transport.Dial(dialCtx, name, addr, opts)
+		cancel()
+		if err == nil {
+			return conn, name, nil
+		}
+
+		if len(transports) > 1 {
+			tc.logger.Printf("Failed to connect over %s to %s: %v", name, addr, err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+	}
+	return nil, "", errors.Join(errs...)
+}
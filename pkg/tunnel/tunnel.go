@@ -1,34 +1,82 @@
+// Package tunnel implements the client side of godig tunnels. NewTunnelClient
+// backs the command line client, Open embeds a tunnel in other programs.
 package tunnel
 
 import (
-	"bufio"
 	"context"
-	"encoding/json"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"io"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
-	"github.com/hashicorp/yamux"
-
+	"github.com/AYM1607/godig/pkg/accesslog"
 	"github.com/AYM1607/godig/pkg/auth"
+	"github.com/AYM1607/godig/pkg/deadline"
+	"github.com/AYM1607/godig/pkg/headers"
+	"github.com/AYM1607/godig/pkg/proxy"
+	"github.com/AYM1607/godig/pkg/session"
+	"github.com/AYM1607/godig/pkg/telemetry"
+	"github.com/AYM1607/godig/pkg/transport"
 	"github.com/AYM1607/godig/types"
 )
 
+// hostLocal is the host header rule that sends the local service address as
+// the host.
+const hostLocal = "local"
+
+// defaultIdleTimeout is used when the server doesn't report the negotiated
+// idle timeout.
+const defaultIdleTimeout = 60 * time.Second
+
 type TunnelClient struct {
 	serverAddr string
 	localAddr  string
 	apiKey     string
-	session    *yamux.Session
-	conn       net.Conn
+	config     types.TunnelClientConfig
+	session    transport.Session
+
+	// serverTLS is used by the transports that reach the server over TLS.
+	serverTLS *tls.Config
+	// proxy returns the proxy the server is reached through.
+	proxy proxy.Func
+
+	// handler serves the requests read from tunnel streams. When nil, the
+	// streams are left to whoever accepts them from listener.
+	handler  http.Handler
+	listener *streamListener
+	logger   *log.Logger
+
+	// onAttempt, if set, is called with the result of every connection
+	// attempt, once the status reflects it.
+	onAttempt func(err error)
+
+	// Stream limits and session settings negotiated with the server in the
+	// last handshake, and the transport carrying the connection.
+	idleTimeout        time.Duration
+	maxRequestDuration time.Duration
+	sessionConfig      types.SessionConfig
+	transport          string
+	// url is the public URL reported by the server, empty for servers that
+	// don't report it.
+	url string
+
+	status tunnelState
 
 	TunnelID string
 	Bearer   *string
 }
 
+// NewTunnelClient returns a client for the tunnel described in
+// godig-tunnel.yaml, in the current directory. A new tunnel ID and bearer are
+// generated when the file doesn't exist, and saved to it with
+// PersistConfig. An empty localAddr hands the tunnel streams to the client
+// Listener instead of a local service.
 func NewTunnelClient(serverAddr, localAddr, apiKey string, clientConfig types.TunnelClientConfig) (*TunnelClient, error) {
 	tunnelConfig, err := loadTunnelConfig()
 	if err != nil {
@@ -36,42 +84,143 @@ func NewTunnelClient(serverAddr, localAddr, apiKey string, clientConfig types.Tu
 	}
 
 	if tunnelConfig == nil {
-		var bearer *string
-		if !clientConfig.DisableAuth {
-			bearerStr, err := auth.GenerateString(20)
-			if err != nil {
-				return nil, fmt.Errorf("failed to generate bearer token: %w", err)
+		tunnelConfig, err = newTunnelConfig("", clientConfig.DisableAuth)
+		if err != nil {
+			return nil, err
+		}
+
+		if clientConfig.PersistConfig {
+			if err := saveTunnelConfig(tunnelConfig); err != nil {
+				return nil, fmt.Errorf("failed to save tunnel config: %w", err)
 			}
-			bearer = &bearerStr
 		}
+	}
 
-		id, err := auth.GenerateString(5)
+	return newTunnelClient(serverAddr, localAddr, apiKey, *tunnelConfig, clientConfig)
+}
+
+// newTunnelConfig returns the config of a new tunnel, with a random ID unless
+// one is given and a random bearer unless auth is disabled.
+func newTunnelConfig(id string, disableAuth bool) (*types.TunnelConfig, error) {
+	var bearer *string
+	if !disableAuth {
+		bearerStr, err := auth.GenerateString(20)
 		if err != nil {
-			return nil, fmt.Errorf("failed to generate tunnel ID: %w", err)
+			return nil, fmt.Errorf("failed to generate bearer token: %w", err)
 		}
+		bearer = &bearerStr
+	}
 
+	if id == "" {
+		var err error
+		id, err = auth.GenerateString(5)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate tunnel ID: %w", err)
+		}
 		id = strings.ToLower(id)
+	}
+
+	return &types.TunnelConfig{
+		TunnelID: id,
+		Bearer:   bearer,
+	}, nil
+}
+
+// newTunnelClient builds a client for the tunnel in tunnelConfig, without
+// touching the filesystem.
+func newTunnelClient(serverAddr, localAddr, apiKey string, tunnelConfig types.TunnelConfig, clientConfig types.TunnelClientConfig) (*TunnelClient, error) {
+	logger := clientConfig.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
 
-		tunnelConfig = &types.TunnelConfig{
-			TunnelID: id,
-			Bearer:   bearer,
+	// Header rules from flags take precedence over the ones in the file.
+	headerConfig := types.HeaderConfig{
+		Host:     tunnelConfig.Headers.Host,
+		Request:  headers.MergeRules(tunnelConfig.Headers.Request, clientConfig.Headers.Request),
+		Response: headers.MergeRules(tunnelConfig.Headers.Response, clientConfig.Headers.Response),
+	}
+	if clientConfig.Headers.Host != "" {
+		headerConfig.Host = clientConfig.Headers.Host
+	}
+
+	var handler http.Handler
+	if localAddr != "" || clientConfig.ServeDir != "" {
+		h, err := newHandler(localAddr, clientConfig, headerConfig, logger)
+		if err != nil {
+			return nil, err
 		}
 
-		if clientConfig.PersistConfig {
-			if err := saveTunnelConfig(tunnelConfig); err != nil {
-				return nil, fmt.Errorf("failed to save tunnel config: %w", err)
-			}
+		logHandler := clientConfig.AccessLog
+		if logHandler == nil {
+			logHandler = slog.NewJSONHandler(os.Stderr, nil)
 		}
+		tunnelID := func(*http.Request) string { return tunnelConfig.TunnelID }
+		handler = telemetry.Handler(
+			accesslog.Handler(h, slog.New(logHandler), tunnelID, clientIP),
+			"godig.tunnel.stream",
+		)
+	}
+
+	if len(clientConfig.Transports) > 0 {
+		if _, err := transport.Parse(strings.Join(clientConfig.Transports, ",")); err != nil {
+			return nil, err
+		}
+	}
+	serverTLS, err := newServerTLSConfig(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	proxyFunc, err := proxy.FromSetting(clientConfig.Proxy)
+	if err != nil {
+		return nil, err
 	}
 
-	return &TunnelClient{
+	tc := &TunnelClient{
 		Bearer:   tunnelConfig.Bearer,
 		TunnelID: tunnelConfig.TunnelID,
 
+		handler: handler,
+		logger:  logger,
+
 		serverAddr: serverAddr,
 		localAddr:  localAddr,
 		apiKey:     apiKey,
-	}, nil
+		config:     clientConfig,
+		serverTLS:  serverTLS,
+		proxy:      proxyFunc,
+	}
+	tc.listener = newStreamListener(tc.URL)
+	return tc, nil
+}
+
+// newHandler returns the handler for requests read from the tunnel, either a
+// proxy to the local service or a static file server, with the header rules
+// applied.
+func newHandler(localAddr string, clientConfig types.TunnelClientConfig, headerConfig types.HeaderConfig, logger *log.Logger) (http.Handler, error) {
+	if clientConfig.ServeDir != "" {
+		handler, err := newStaticHandler(clientConfig.ServeDir, clientConfig.DirListing, clientConfig.SPA)
+		if err != nil {
+			return nil, err
+		}
+		return headers.RulesHandler(handler, "", headerConfig.Request, headerConfig.Response), nil
+	}
+
+	up, err := newUpstream(localAddr, clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	host := headerConfig.Host
+	if host == hostLocal {
+		host = up.target.Host
+	}
+	return headers.RulesHandler(newLocalProxy(up, logger), host, headerConfig.Request, headerConfig.Response), nil
+}
+
+// clientIP returns the public client address reported by the tunnel server.
+func clientIP(r *http.Request) string {
+	return r.Header.Get("X-Real-IP")
 }
 
 // sleepUntilOrCancelled sleeps for the given duration or returns early if the context is cancelled.
@@ -91,23 +240,47 @@ func (tc *TunnelClient) Run(ctx context.Context) {
 		TunnelID: tc.TunnelID,
 		APIKey:   tc.apiKey,
 		Bearer:   tc.Bearer,
+
+		MaxRequestBodyBytes: tc.config.MaxRequestBodyBytes,
+	}
+	if tc.config.IdleTimeout > 0 {
+		hm.IdleTimeout = tc.config.IdleTimeout.String()
+	}
+	if tc.config.MaxRequestDuration > 0 {
+		hm.MaxRequestDuration = tc.config.MaxRequestDuration.String()
 	}
+	hm.Session = session.Encode(tc.config.Session)
+
+	// Streams from every session are served by the same HTTP server, so
+	// requests in flight are not affected by the reconnection logic.
+	defer tc.listener.Close()
+	if tc.handler != nil {
+		server := &http.Server{Handler: tc.handler, ErrorLog: tc.logger}
+		go server.Serve(tc.listener)
+		defer server.Close()
+	}
+
+	tc.status.start()
+	defer tc.status.stopped()
 
 	// TODO: Try to get the message from the persisted file.
 	// TODO: Exponential backoffs for retries.
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Context cancelled, stopping tunnel client")
+			tc.logger.Println("Context cancelled, stopping tunnel client")
 			return
 		default:
 		}
 
-		log.Printf("Attempting to connect to tunnel server at %s", tc.serverAddr)
+		tc.logger.Printf("Attempting to connect to tunnel server at %s", tc.serverAddr)
+		tc.status.connecting()
 
-		if err := tc.connect(hm); err != nil {
-			log.Printf("Failed to connect: %v", err)
-			log.Println("Retrying in 5 seconds...")
+		if err := tc.connect(ctx, hm); err != nil {
+			tc.status.failed(err)
+			tc.attempted(err)
+			tc.logger.Printf("Failed to connect: %v", err)
+			tc.logger.Println("Retrying in 5 seconds...")
 
 			if sleepUntilOrCancelled(ctx, 5*time.Second) {
 				return
@@ -117,18 +290,33 @@ func (tc *TunnelClient) Run(ctx context.Context) {
 
 		// TODO: Once a connection is accepted, persist it to a file in the current directory.
 
+		tc.status.connected(tc.transport, tc.url)
+		tc.attempted(nil)
+
+		// A missed heartbeat closes the session, which ends start.
+		heartbeatCtx, stopHeartbeat := context.WithCancel(ctx)
+		heartbeatErr := make(chan error, 1)
+		go func() {
+			heartbeatErr <- session.Heartbeat(heartbeatCtx, tc.session, tc.heartbeatInterval(), tc.status.heartbeat)
+		}()
+
 		// Start handling streams
-		tc.start(ctx)
+		err := tc.start(ctx)
 
 		// Connection lost, cleanup and retry
+		stopHeartbeat()
 		if tc.session != nil {
 			tc.session.Close()
 		}
-		if tc.conn != nil {
-			tc.conn.Close()
+
+		if hbErr := <-heartbeatErr; hbErr != nil {
+			err = hbErr
+		}
+		if ctx.Err() == nil {
+			tc.status.failed(fmt.Errorf("connection lost: %w", err))
 		}
 
-		log.Println("Connection lost. Reconnecting in 5 seconds...")
+		tc.logger.Println("Connection lost. Reconnecting in 5 seconds...")
 
 		if sleepUntilOrCancelled(ctx, 5*time.Second) {
 			return
@@ -137,109 +325,151 @@ func (tc *TunnelClient) Run(ctx context.Context) {
 }
 
 // TODO: Pass the handshake message as a parameter.
-func (tc *TunnelClient) connect(hm types.HandshakeMessage) error {
+func (tc *TunnelClient) connect(ctx context.Context, hm types.HandshakeMessage) error {
 	// Connect to tunnel server
-	conn, err := net.Dial("tcp", tc.serverAddr)
+	conn, transportName, err := tc.dial(ctx)
 	if err != nil {
 		return err
 	}
 
-	encoder := json.NewEncoder(conn)
-	if err := encoder.Encode(hm); err != nil {
+	if err := conn.Encode(hm); err != nil {
 		conn.Close()
 		return err
 	}
 
 	// Wait for acknowledgment
-	decoder := json.NewDecoder(conn)
-	var response map[string]string
-	if err := decoder.Decode(&response); err != nil {
+	var response types.HandshakeResponse
+	if err := conn.Decode(&response); err != nil {
 		conn.Close()
 		return err
 	}
 
-	if response["status"] != "ok" {
+	if response.Status != "ok" {
 		conn.Close()
-		return fmt.Errorf("handshake failed: %v", response)
+		return fmt.Errorf("handshake failed: %+v", response)
 	}
 
-	// Create yamux session
-	session, err := yamux.Client(conn, yamux.DefaultConfig())
+	idleTimeout, maxRequestDuration, err := parseStreamLimits(response)
 	if err != nil {
 		conn.Close()
 		return err
 	}
 
-	tc.conn = conn
-	tc.session = session
+	// Older servers don't report the session settings, use the requested
+	// ones.
+	sessionConfig := tc.config.Session
+	if response.Session != nil {
+		sessionConfig, err = session.Decode(response.Session)
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("invalid session settings in handshake response: %w", err)
+		}
+	}
+
+	muxSession, err := conn.Session(sessionConfig)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	tc.session = muxSession
+	tc.transport = transportName
+	tc.idleTimeout = idleTimeout
+	tc.maxRequestDuration = maxRequestDuration
+	tc.sessionConfig = sessionConfig
+	tc.url = response.URL
 
-	log.Printf("Connected to tunnel server. Public URL: https://%s.%s", tc.TunnelID, tc.serverAddr)
+	tc.logger.Printf("Connected to tunnel server over %s. Public URL: %s", transportName, tc.publicURL(tc.url))
 	return nil
 }
 
-func (tc *TunnelClient) start(ctx context.Context) {
+// attempted reports the result of a connection attempt to onAttempt.
+func (tc *TunnelClient) attempted(err error) {
+	if tc.onAttempt != nil {
+		tc.onAttempt(err)
+	}
+}
+
+// heartbeatInterval returns the negotiated heartbeat interval, or the default
+// one for servers that don't report it.
+func (tc *TunnelClient) heartbeatInterval() time.Duration {
+	if tc.sessionConfig.HeartbeatInterval > 0 {
+		return tc.sessionConfig.HeartbeatInterval
+	}
+	return session.Defaults().HeartbeatInterval
+}
+
+// URL returns the public URL of the tunnel, as reported by the server once
+// connected.
+func (tc *TunnelClient) URL() string {
+	s := &tc.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return tc.publicURL(s.url)
+}
+
+// publicURL returns reported, the URL reported by the server, or a guess
+// based on the server host until connected to a server that reports it.
+func (tc *TunnelClient) publicURL(reported string) string {
+	if reported != "" {
+		return reported
+	}
+	host, _, err := net.SplitHostPort(tc.serverAddr)
+	if err != nil {
+		host = tc.serverAddr
+	}
+	return fmt.Sprintf("https://%s.%s", tc.TunnelID, host)
+}
+
+// start hands the streams opened by the server to the listener until the session
+// ends, returning the error that ended it.
+func (tc *TunnelClient) start(ctx context.Context) error {
 	for {
-		stream, err := tc.session.AcceptStreamWithContext(ctx)
+		stream, err := tc.session.AcceptStream(ctx)
 		if err != nil {
-			log.Printf("Failed to accept stream: %v", err)
-			break
+			tc.logger.Printf("Failed to accept stream: %v", err)
+			return err
 		}
 
-		// Handle each stream in a goroutine
-		go tc.handleStream(ctx, stream)
+		tc.handleStream(stream)
 	}
 }
 
-func (tc *TunnelClient) handleStream(ctx context.Context, stream net.Conn) {
-	defer stream.Close()
-
-	// Set up a goroutine to close the stream if context is cancelled
-	go func() {
-		<-ctx.Done()
-		stream.Close()
-	}()
-
-	// Set timeout ONLY for reading the initial HTTP request
-	stream.SetReadDeadline(time.Now().Add(30 * time.Second))
+// parseStreamLimits reads the stream limits the server settled on. Older
+// servers don't send them, in which case the stream is only bounded by the
+// default idle timeout.
+func parseStreamLimits(response types.HandshakeResponse) (time.Duration, time.Duration, error) {
+	idleTimeout := defaultIdleTimeout
+	if response.IdleTimeout != "" {
+		d, err := time.ParseDuration(response.IdleTimeout)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid idle timeout in handshake response: %w", err)
+		}
+		idleTimeout = d
+	}
 
-	// Read HTTP request from the stream
-	req, err := http.ReadRequest(bufio.NewReader(stream))
-	if err != nil {
-		log.Printf("Failed to read request from stream: %v", err)
-		return
+	var maxRequestDuration time.Duration
+	if response.MaxRequestDuration != "" {
+		d, err := time.ParseDuration(response.MaxRequestDuration)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid max request duration in handshake response: %w", err)
+		}
+		maxRequestDuration = d
 	}
 
-	// IMPORTANT: Clear deadline immediately after reading request
-	// This allows long-running connections (SSE, WebSocket, etc.)
-	stream.SetReadDeadline(time.Time{})
+	return idleTimeout, maxRequestDuration, nil
+}
 
-	log.Printf("Handling request: %s %s", req.Method, req.URL.Path)
+func (tc *TunnelClient) handleStream(stream net.Conn) {
+	// The deadline is renewed on every read and write, which allows
+	// long-running connections (SSE, WebSocket, etc.) as long as they are not
+	// idle.
+	conn := deadline.NewConn(stream, tc.idleTimeout, tc.maxRequestDuration)
 
-	// Connect to local service
-	localConn, err := net.Dial("tcp", tc.localAddr)
-	if err != nil {
-		log.Printf("Failed to connect to local service: %v", err)
-		// Send error response
-		errorResp := "HTTP/1.1 502 Bad Gateway\r\nContent-Length: 0\r\n\r\n"
-		stream.Write([]byte(errorResp))
-		return
-	}
-	defer localConn.Close()
-
-	// Forward the request to local service
-	if err := req.Write(localConn); err != nil {
-		log.Printf("Failed to write request to local service: %v", err)
-		return
-	}
-
-	// Copy response from local service back to tunnel stream
-	// This handles both regular HTTP responses and streaming responses (SSE, chunked, etc.)
-	go func() {
-		defer stream.Close()
-		defer localConn.Close()
-		io.Copy(stream, localConn)
-	}()
-
-	// Copy any remaining request data (for uploads, etc.)
-	io.Copy(localConn, stream)
+	if err := tc.listener.deliver(conn); err != nil {
+		if !errors.Is(err, net.ErrClosed) {
+			tc.logger.Printf("Refused stream: %v", err)
+		}
+		stream.Close()
+	}
 }
@@ -0,0 +1,243 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/AYM1607/godig/pkg/headers"
+	"github.com/AYM1607/godig/pkg/telemetry"
+	"github.com/AYM1607/godig/types"
+)
+
+// upstream describes how to reach the local service.
+type upstream struct {
+	// target holds the scheme and host used in requests to the local service.
+	target *url.URL
+	// network and address are dialed to reach the local service, either a TCP
+	// address or a Unix socket path.
+	network string
+	address string
+	// tlsConfig is non-nil when the local service is reached over TLS.
+	tlsConfig *tls.Config
+	h2c       bool
+	dialer    *net.Dialer
+	// proxyProtocol is the PROXY protocol version sent on every connection,
+	// empty when disabled.
+	proxyProtocol string
+}
+
+// newUpstream parses the local service address, either host:port or a URL
+// such as https://localhost:8443 or unix:///path/to.sock, and its connection
+// options.
+func newUpstream(localAddr string, clientConfig types.TunnelClientConfig) (*upstream, error) {
+	target, network, address, err := parseLocalTarget(localAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := headers.ValidateProxyProtocol(clientConfig.ProxyProtocol); err != nil {
+		return nil, err
+	}
+
+	up := &upstream{
+		target:  target,
+		network: network,
+		address: address,
+		h2c:     clientConfig.H2C,
+		dialer: &net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		},
+		proxyProtocol: clientConfig.ProxyProtocol,
+	}
+
+	if target.Scheme == "https" {
+		if clientConfig.H2C {
+			return nil, fmt.Errorf("h2c can't be used with an https local service")
+		}
+		up.tlsConfig, err = newUpstreamTLSConfig(clientConfig.LocalTLS)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return up, nil
+}
+
+// parseLocalTarget parses a local service address into the URL used in
+// requests and the network address to dial. Addresses without a scheme are
+// plain HTTP.
+func parseLocalTarget(localAddr string) (*url.URL, string, string, error) {
+	if !strings.Contains(localAddr, ":/") && !strings.HasPrefix(localAddr, "unix:") {
+		localAddr = "http://" + localAddr
+	}
+
+	target, err := url.Parse(localAddr)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("invalid local address: %w", err)
+	}
+
+	switch target.Scheme {
+	case "http", "https":
+		if target.Host == "" {
+			return nil, "", "", fmt.Errorf("invalid local address: missing host")
+		}
+		// Only the scheme and host are used, requests keep their own path.
+		return &url.URL{Scheme: target.Scheme, Host: target.Host}, "tcp", target.Host, nil
+	case "unix":
+		// Both unix:///abs/path.sock and unix:rel/path.sock are accepted.
+		path := target.Path
+		if path == "" {
+			path = target.Opaque
+		}
+		if path == "" {
+			return nil, "", "", fmt.Errorf("invalid local address: missing socket path")
+		}
+		// Sockets have no host, localhost is what HTTP servers behind them
+		// expect when one is needed.
+		return &url.URL{Scheme: "http", Host: "localhost"}, "unix", path, nil
+	default:
+		return nil, "", "", fmt.Errorf("unsupported local address scheme: %s", target.Scheme)
+	}
+}
+
+func newUpstreamTLSConfig(cfg types.LocalTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		pool, err := loadCAPool(cfg.CAFile, "local CA file")
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, fmt.Errorf("local client certificate and key must be set together")
+	}
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load local client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// loadCAPool returns the system roots with the certificates in the PEM file
+// added. name describes the file in errors.
+func loadCAPool(file, name string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s %s", name, file)
+	}
+	return pool, nil
+}
+
+// newTransport returns the transport used to forward requests to the local
+// service. Connections are kept alive and reused across tunnel streams so busy
+// tunnels don't exhaust local ephemeral ports.
+func (up *upstream) newTransport() *http.Transport {
+	transport := &http.Transport{
+		// Every request goes to the same local service, which may be a Unix
+		// socket, so the address from the request is ignored.
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return up.dialContext(ctx)
+		},
+		TLSClientConfig:     up.tlsConfig,
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+		// Compression is negotiated end to end, bodies go through untouched.
+		DisableCompression: true,
+		// The PROXY protocol header describes a single public connection,
+		// connections can't be shared by requests from different clients.
+		DisableKeepAlives: up.proxyProtocol != "",
+	}
+
+	var protocols http.Protocols
+	switch {
+	case up.h2c:
+		// gRPC servers expect HTTP/2 over cleartext.
+		protocols.SetUnencryptedHTTP2(true)
+	case up.tlsConfig != nil:
+		// A custom TLS config disables HTTP/2 unless asked for explicitly.
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	default:
+		protocols.SetHTTP1(true)
+	}
+	transport.Protocols = &protocols
+
+	return transport
+}
+
+// dialContext connects to the local service, the connection setup is traced
+// as a child of the request span in ctx. When enabled, the PROXY protocol
+// header announces the public connection of the request in ctx.
+func (up *upstream) dialContext(ctx context.Context) (net.Conn, error) {
+	ctx, span := telemetry.StartSpan(ctx, "godig.local.dial",
+		attribute.String("network.transport", up.network),
+		attribute.String("server.address", up.address),
+	)
+	conn, err := up.dialer.DialContext(ctx, up.network, up.address)
+	if err == nil && up.proxyProtocol != "" {
+		public := publicConnFromContext(ctx)
+		if err = headers.WriteProxyHeader(conn, up.proxyProtocol, public.src, public.dst); err != nil {
+			conn.Close()
+			conn = nil
+		}
+	}
+	telemetry.EndSpan(span, err)
+	return conn, err
+}
+
+// dial opens a raw connection to the local service, used for upgraded
+// connections which bypass the transport.
+func (up *upstream) dial(ctx context.Context) (net.Conn, error) {
+	conn, err := up.dialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if up.tlsConfig == nil {
+		return conn, nil
+	}
+
+	tlsConfig := up.tlsConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = up.target.Hostname()
+	}
+	// Upgrades are only defined for HTTP/1.1.
+	tlsConfig.NextProtos = []string{"http/1.1"}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
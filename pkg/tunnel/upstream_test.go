@@ -0,0 +1,189 @@
+package tunnel
+
+import (
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/AYM1607/godig/types"
+)
+
+func TestParseLocalTarget(t *testing.T) {
+	tests := []struct {
+		name            string
+		localAddr       string
+		expected        string
+		expectedNetwork string
+		expectedAddress string
+		expectError     bool
+	}{
+		{
+			name:            "host and port",
+			localAddr:       "localhost:3000",
+			expected:        "http://localhost:3000",
+			expectedNetwork: "tcp",
+			expectedAddress: "localhost:3000",
+		},
+		{
+			name:            "https url",
+			localAddr:       "https://localhost:8443",
+			expected:        "https://localhost:8443",
+			expectedNetwork: "tcp",
+			expectedAddress: "localhost:8443",
+		},
+		{
+			name:            "path is dropped",
+			localAddr:       "http://localhost:3000/app",
+			expected:        "http://localhost:3000",
+			expectedNetwork: "tcp",
+			expectedAddress: "localhost:3000",
+		},
+		{
+			name:            "absolute unix socket",
+			localAddr:       "unix:///run/app.sock",
+			expected:        "http://localhost",
+			expectedNetwork: "unix",
+			expectedAddress: "/run/app.sock",
+		},
+		{
+			name:            "relative unix socket",
+			localAddr:       "unix:app.sock",
+			expected:        "http://localhost",
+			expectedNetwork: "unix",
+			expectedAddress: "app.sock",
+		},
+		{
+			name:        "unix without path",
+			localAddr:   "unix://",
+			expectError: true,
+		},
+		{
+			name:        "unsupported scheme",
+			localAddr:   "ftp://localhost:21",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, network, address, err := parseLocalTarget(tt.localAddr)
+
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if target.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, target.String())
+			}
+			if network != tt.expectedNetwork || address != tt.expectedAddress {
+				t.Errorf("expected %s %q, got %s %q", tt.expectedNetwork, tt.expectedAddress, network, address)
+			}
+		})
+	}
+}
+
+func TestUpstream_HTTPS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatalf("failed to write CA file: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		tlsConfig   types.LocalTLSConfig
+		expectError bool
+	}{
+		{
+			name:        "untrusted certificate",
+			expectError: true,
+		},
+		{
+			name:      "skip verification",
+			tlsConfig: types.LocalTLSConfig{InsecureSkipVerify: true},
+		},
+		{
+			name: "custom CA and server name",
+			tlsConfig: types.LocalTLSConfig{
+				CAFile:     caFile,
+				ServerName: "example.com",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up, err := newUpstream(server.URL, types.TunnelClientConfig{LocalTLS: tt.tlsConfig})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			client := &http.Client{Transport: up.newTransport()}
+			resp, err := client.Get(up.target.String())
+
+			if tt.expectError {
+				if err == nil {
+					resp.Body.Close()
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("expected status 200, got %d", resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestUpstream_Unix(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "app.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to listen on unix socket: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host)
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	up, err := newUpstream("unix://"+socketPath, types.TunnelClientConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	client := &http.Client{Transport: up.newTransport()}
+	resp, err := client.Get(up.target.String() + "/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "localhost" {
+		t.Errorf("expected host localhost, got %q", body)
+	}
+}
@@ -1,17 +1,197 @@
 package types
 
+import (
+	"log"
+	"log/slog"
+	"time"
+)
+
 type HandshakeMessage struct {
 	TunnelID string  `json:"tunnelID"`
 	APIKey   string  `json:"apiKey"`
 	Bearer   *string `json:"bearer"`
+
+	// MaxRequestBodyBytes optionally lowers the server's request body limit
+	// for this tunnel.
+	MaxRequestBodyBytes int64 `json:"maxRequestBodyBytes,omitempty"`
+
+	// IdleTimeout and MaxRequestDuration are Go duration strings asking the
+	// server for per-tunnel stream limits. Empty values use the server
+	// defaults.
+	IdleTimeout        string `json:"idleTimeout,omitempty"`
+	MaxRequestDuration string `json:"maxRequestDuration,omitempty"`
+
+	// Session optionally asks for session settings, the server keeps them
+	// within its bounds.
+	Session *SessionSettings `json:"session,omitempty"`
+}
+
+// HandshakeResponse is sent by the server once the handshake is accepted. The
+// durations are the limits that the server settled on for the tunnel, encoded
+// as Go duration strings.
+type HandshakeResponse struct {
+	Status string `json:"status"`
+	// URL is the public URL the tunnel is served at.
+	URL                string `json:"url,omitempty"`
+	IdleTimeout        string `json:"idleTimeout,omitempty"`
+	MaxRequestDuration string `json:"maxRequestDuration,omitempty"`
+
+	Session *SessionSettings `json:"session,omitempty"`
+}
+
+// SessionSettings are the yamux session settings exchanged in the handshake.
+// Durations are Go duration strings, empty values use the defaults.
+type SessionSettings struct {
+	MaxStreamWindowSize    uint32 `json:"maxStreamWindowSize,omitempty"`
+	KeepAliveInterval      string `json:"keepAliveInterval,omitempty"`
+	ConnectionWriteTimeout string `json:"connectionWriteTimeout,omitempty"`
+	HeartbeatInterval      string `json:"heartbeatInterval,omitempty"`
+}
+
+// SessionConfig tunes the multiplexed session between a client and the
+// server. Zero values use the defaults.
+type SessionConfig struct {
+	// MaxStreamWindowSize is the largest receive window of a stream, in
+	// bytes. Larger windows speed up big transfers over high latency links.
+	MaxStreamWindowSize uint32
+	// KeepAliveInterval is how often yamux pings the other end.
+	KeepAliveInterval time.Duration
+	// ConnectionWriteTimeout bounds writes and pings on the connection.
+	ConnectionWriteTimeout time.Duration
+	// HeartbeatInterval is how often the application level heartbeat checks
+	// that the other end answers. A missed heartbeat closes the session.
+	HeartbeatInterval time.Duration
 }
 
 type TunnelConfig struct {
 	TunnelID string  `yaml:"tunnel_id"`
 	Bearer   *string `yaml:"bearer,omitempty"`
+
+	Headers HeaderConfig `yaml:"headers,omitempty"`
+}
+
+// HeaderConfig holds the header manipulation rules of a tunnel.
+type HeaderConfig struct {
+	// Host replaces the public host in requests to the local service, the
+	// special value "local" uses the local service address.
+	Host     string      `yaml:"host,omitempty"`
+	Request  HeaderRules `yaml:"request,omitempty"`
+	Response HeaderRules `yaml:"response,omitempty"`
+}
+
+// HeaderRules are changes applied to a set of headers. Removals are applied
+// first, then sets and finally additions.
+type HeaderRules struct {
+	Set    map[string]string       `yaml:"set,omitempty"`
+	Add    map[string]HeaderValues `yaml:"add,omitempty"`
+	Remove []string                `yaml:"remove,omitempty"`
+}
+
+// HeaderValues are the values added to a header. In YAML they are a list, or
+// a single string for one value.
+type HeaderValues []string
+
+func (v *HeaderValues) UnmarshalYAML(unmarshal func(any) error) error {
+	var value string
+	if err := unmarshal(&value); err == nil {
+		*v = HeaderValues{value}
+		return nil
+	}
+	var values []string
+	if err := unmarshal(&values); err != nil {
+		return err
+	}
+	*v = values
+	return nil
 }
 
 type TunnelClientConfig struct {
-	PersistConfig bool
-	DisableAuth   bool
+	PersistConfig       bool
+	DisableAuth         bool
+	MaxRequestBodyBytes int64
+	IdleTimeout         time.Duration
+	MaxRequestDuration  time.Duration
+	// H2C makes the client speak HTTP/2 over cleartext to the local service,
+	// as required by gRPC servers.
+	H2C bool
+	// LocalTLS configures TLS to local services reached over https.
+	LocalTLS LocalTLSConfig
+	// Headers are merged with the rules from the tunnel config file, taking
+	// precedence over them.
+	Headers HeaderConfig
+	// ProxyProtocol, "v1" or "v2", sends a PROXY protocol header with the
+	// public connection addresses on every connection to the local service.
+	// Connections are then used for a single request.
+	ProxyProtocol string
+	// Session tunes the session with the server, within the bounds the
+	// server allows.
+	Session SessionConfig
+	// AccessLog receives the access log of every request served through the
+	// tunnel. JSON lines are written to stderr when nil.
+	AccessLog slog.Handler
+	// Logger receives the connection and error logs of the client, the
+	// standard logger is used when nil.
+	Logger *log.Logger
+
+	// Transports lists the transports tried in order to reach the server:
+	// tcp, websocket or quic. Empty uses tcp only.
+	Transports []string
+	// WebSocketURL is where the server accepts tunnels over a WebSocket,
+	// wss://<server host>/_godig/tunnel by default.
+	WebSocketURL string
+	// QUICAddr is the UDP address of the server QUIC listener, the server
+	// address by default.
+	QUICAddr string
+	// ServerCAFile is a PEM bundle trusted on top of the system roots by the
+	// QUIC and secure WebSocket transports.
+	ServerCAFile string
+	// Proxy is the URL of the HTTP CONNECT or SOCKS5 proxy the server is
+	// reached through, "direct" for none. Empty uses HTTPS_PROXY or ALL_PROXY.
+	Proxy string
+
+	// ServeDir makes the client serve this directory through the tunnel
+	// instead of proxying to a local service.
+	ServeDir string
+	// DirListing enables directory listings when serving a directory.
+	DirListing bool
+	// SPA answers unknown paths with the root index.html when serving a
+	// directory.
+	SPA bool
+}
+
+// LocalTLSConfig holds the TLS options used to reach a local https service.
+type LocalTLSConfig struct {
+	InsecureSkipVerify bool
+	// CAFile is a PEM bundle trusted on top of the system roots.
+	CAFile string
+	// ServerName overrides the SNI and the name verified in the certificate.
+	ServerName string
+	// CertFile and KeyFile hold a client certificate for mutual TLS.
+	CertFile string
+	KeyFile  string
+}
+
+// TunnelStatus is the state of a tunnel client, as reported by its status
+// endpoint.
+type TunnelStatus struct {
+	// State is one of connecting, connected, disconnected or stopped.
+	State    string `json:"state"`
+	TunnelID string `json:"tunnelID"`
+	URL      string `json:"url"`
+	Server   string `json:"server"`
+	// Transport carries the current connection, only set while connected.
+	Transport string `json:"transport,omitempty"`
+
+	StartedAt   time.Time  `json:"startedAt"`
+	Uptime      string     `json:"uptime"`
+	ConnectedAt *time.Time `json:"connectedAt,omitempty"`
+	// Reconnects counts the connections established after the first one.
+	Reconnects  int        `json:"reconnects"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+
+	// LastHeartbeat and HeartbeatRTT describe the last heartbeat answered
+	// by the server in the current connection.
+	LastHeartbeat *time.Time `json:"lastHeartbeat,omitempty"`
+	HeartbeatRTT  string     `json:"heartbeatRTT,omitempty"`
 }